@@ -1,7 +1,35 @@
 package main
 
-import "github.com/blvckbill/redis-from-scratch/internal/server"
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/blvckbill/redis-from-scratch/internal/config"
+	"github.com/blvckbill/redis-from-scratch/internal/server"
+)
 
 func main() {
-	server.NewServer().Start()
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Fatal: could not load config: %v", err)
+	}
+	srv := server.NewServer(cfg)
+
+	// shut down gracefully on SIGINT and SIGTERM so the AOF is flushed before exiting
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			log.Printf("Received %s, scheduling shutdown...", sig)
+			if err := srv.Shutdown(server.ShutdownOptions{}); err != nil {
+				log.Printf("Error trying to shut down the server: %v", err)
+			}
+		}
+	}()
+
+	if err := srv.Start(); err != nil {
+		log.Fatalf("Fatal: %v", err)
+	}
 }
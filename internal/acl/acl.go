@@ -0,0 +1,380 @@
+package acl
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultUser is the user every connection starts as, it is what requirepass configures.
+const DefaultUser = "default"
+
+var (
+	errSyntax         = errors.New("Syntax error")
+	errUnknownCommand = errors.New("Unknown command or category name in ACL")
+)
+
+// Categories is every ACL category, in the order ACL CAT lists them.
+var Categories = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string", "bitmap",
+	"hyperloglog", "geo", "stream", "pubsub", "admin", "fast", "slow", "blocking",
+	"dangerous", "connection", "transaction", "scripting",
+}
+
+/*
+Command describes a command to the ACL: its name, its categories and whether it has subcommands.
+Subcommands lists the categories some subcommands are in on top of the container's, so that
+for example -@dangerous can deny ACL SETUSER while leaving ACL WHOAMI alone.
+*/
+type Command struct {
+	Name        string
+	Categories  []string
+	Container   bool
+	Subcommands map[string][]string
+}
+
+/*
+ACL holds the users of the server and decides what each of them may do.
+The default user always exists, it starts out able to run everything without a password.
+*/
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*User
+
+	commands   map[string][]string // command name to its categories
+	categories map[string][]string // category name to its commands and command|subcommand pairs, "all" holds every command
+	containers map[string]bool     // commands that take a subcommand
+
+	log *Log
+}
+
+// New returns an ACL that knows about the given commands and holds only the default user.
+func New(commands []Command) *ACL {
+	a := &ACL{
+		users:      make(map[string]*User),
+		commands:   make(map[string][]string),
+		categories: make(map[string][]string),
+		containers: make(map[string]bool),
+		log:        newLog(),
+	}
+	for _, cat := range Categories {
+		a.categories[cat] = nil
+	}
+	for _, cmd := range commands {
+		a.commands[cmd.Name] = cmd.Categories
+		a.containers[cmd.Name] = cmd.Container
+		a.categories["all"] = append(a.categories["all"], cmd.Name)
+		for _, cat := range cmd.Categories {
+			a.categories[cat] = append(a.categories[cat], cmd.Name)
+		}
+		for sub, cats := range cmd.Subcommands {
+			for _, cat := range cats {
+				a.categories[cat] = append(a.categories[cat], cmd.Name+"|"+sub)
+			}
+		}
+	}
+	for _, cmds := range a.categories {
+		sort.Strings(cmds)
+	}
+	a.users[DefaultUser] = a.defaultUser()
+	return a
+}
+
+func (a *ACL) defaultUser() *User {
+	u := newUser(DefaultUser)
+	for _, rule := range []string{"on", "nopass", "~*", "&*", "+@all"} {
+		a.applyRule(u, rule)
+	}
+	return u
+}
+
+// RuleError is returned when an ACL rule cannot be applied.
+type RuleError struct {
+	Rule string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	return "Error in ACL SETUSER modifier '" + e.Rule + "': " + e.Err.Error()
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+/*
+SetUser creates the user if it does not exist and applies the rules to it in order.
+Either every rule is applied or, if one of them is invalid, the user is left untouched.
+*/
+func (a *ACL) SetUser(name string, rules []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u, err := a.buildUser(a.users[name], name, rules)
+	if err != nil {
+		return err
+	}
+	a.users[name] = u
+	return nil
+}
+
+// buildUser applies rules to a copy of u, or to a new user when u is nil.
+func (a *ACL) buildUser(u *User, name string, rules []string) (*User, error) {
+	if u == nil {
+		u = newUser(name)
+	} else {
+		u = u.clone()
+	}
+	for _, rule := range rules {
+		if err := a.applyRule(u, rule); err != nil {
+			return nil, &RuleError{Rule: rule, Err: err}
+		}
+	}
+	return u, nil
+}
+
+// DelUser deletes the named users and returns how many of them existed. The default user cannot be deleted.
+func (a *ACL) DelUser(names ...string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, name := range names {
+		if name == DefaultUser {
+			return 0, errors.New("The 'default' user cannot be removed")
+		}
+	}
+	n := 0
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Exists reports whether the named user exists.
+func (a *ACL) Exists(name string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	_, ok := a.users[name]
+	return ok
+}
+
+// Users returns the names of every user, sorted.
+func (a *ACL) Users() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List returns the description of every user as ACL LIST shows it, sorted by name.
+func (a *ACL) List() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.list()
+}
+
+func (a *ACL) list() []string {
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = a.users[name].describe()
+	}
+	return lines
+}
+
+// UserInfo is what ACL GETUSER reports about a user.
+type UserInfo struct {
+	Flags     []string
+	Passwords []string
+	Commands  string
+	Keys      string
+	Channels  string
+}
+
+// GetUser returns the description of the named user.
+func (a *ACL) GetUser(name string) (UserInfo, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, ok := a.users[name]
+	if !ok {
+		return UserInfo{}, false
+	}
+	return UserInfo{
+		Flags:     u.flags(),
+		Passwords: append([]string(nil), u.passwords...),
+		Commands:  u.commandsDescription(),
+		Keys:      u.keysDescription(),
+		Channels:  u.channelsDescription(),
+	}, true
+}
+
+// NoPass reports whether the named user is enabled and accepts any password, i.e. needs no AUTH.
+func (a *ACL) NoPass(name string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, ok := a.users[name]
+	return ok && u.enabled && u.nopass
+}
+
+/*
+Authenticate checks a username and password pair.
+The user must exist, be enabled, and either be nopass or have the password among its passwords.
+The comparison runs in constant time over the password hashes.
+*/
+func (a *ACL) Authenticate(name, password string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, ok := a.users[name]
+	if !ok || !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+	h := []byte(hashPassword(password))
+	match := 0
+	for _, p := range u.passwords {
+		match |= subtle.ConstantTimeCompare(h, []byte(p))
+	}
+	return match == 1
+}
+
+// Denial reasons, as reported by ACL LOG.
+const (
+	ReasonCommand = "command"
+	ReasonKey     = "key"
+	ReasonChannel = "channel"
+	ReasonAuth    = "auth"
+)
+
+/*
+Check reports whether the named user may run command, with sub being its subcommand for
+container commands, on the given keys and channels.
+When it may not, Check returns the reason and the object that was denied.
+*/
+func (a *ACL) Check(name, command, sub string, keys, channels []string) (reason, object string, ok bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, exists := a.users[name]
+	if !exists {
+		return ReasonCommand, command, false
+	}
+	if !u.canRun(command, sub) {
+		if sub != "" && a.containers[command] {
+			return ReasonCommand, command + "|" + sub, false
+		}
+		return ReasonCommand, command, false
+	}
+	for _, key := range keys {
+		if !u.canAccessKey(key) {
+			return ReasonKey, key, false
+		}
+	}
+	for _, channel := range channels {
+		if !u.canAccessChannel(channel) {
+			return ReasonChannel, channel, false
+		}
+	}
+	return "", "", true
+}
+
+// CategoryCommands returns the commands in a category, sorted.
+func (a *ACL) CategoryCommands(category string) ([]string, bool) {
+	category = strings.ToLower(category)
+	cmds, ok := a.categories[category]
+	if !ok || category == "all" {
+		return nil, false
+	}
+	return cmds, true
+}
+
+// Log returns the log of denied commands and failed authentications.
+func (a *ACL) Log() *Log {
+	return a.log
+}
+
+/*
+LoadFile replaces every user with the ones defined in an ACL file.
+Each non blank line that is not a comment has the form "user <name> <rule> ...".
+If any line is invalid no user is changed. The default user is created with its
+usual rules if the file does not define it.
+*/
+func (a *ACL) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: should start with user keyword and a username", path, lineno)
+		}
+		name := fields[1]
+		if _, dup := users[name]; dup {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", path, lineno, name)
+		}
+		u, err := a.buildUser(nil, name, fields[2:])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineno, err)
+		}
+		users[name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = a.defaultUser()
+	}
+
+	a.mu.Lock()
+	a.users = users
+	a.mu.Unlock()
+	return nil
+}
+
+// SaveFile writes every user to an ACL file that LoadFile can read back.
+func (a *ACL) SaveFile(path string) error {
+	a.mu.RLock()
+	lines := a.list()
+	a.mu.RUnlock()
+
+	// write to a temporary file first so a crash never leaves a half written ACL file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,96 @@
+package acl
+
+import (
+	"sync"
+	"time"
+)
+
+// logMaxLen bounds the number of entries the log keeps, like acllog-max-len in Redis.
+const logMaxLen = 128
+
+// similar entries within this window are folded into a single entry
+const logGroupWindow = 60 * time.Second
+
+// LogEntry is one ACL LOG entry, a denied command or failed authentication.
+type LogEntry struct {
+	ID         int64
+	Count      int64
+	Reason     string
+	Context    string
+	Object     string
+	Username   string
+	ClientInfo string
+	Created    time.Time
+	Updated    time.Time
+}
+
+// Log is the list of recent ACL denials, newest first.
+type Log struct {
+	mu      sync.Mutex
+	entries []*LogEntry
+	nextID  int64
+}
+
+func newLog() *Log {
+	return &Log{}
+}
+
+/*
+Add records a denial. A denial with the same reason, context, object and username as a
+recent entry only bumps that entry's count, the way Redis groups repeated failures.
+*/
+func (l *Log) Add(reason, context, object, username, clientInfo string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, e := range l.entries {
+		if e.Reason == reason && e.Context == context && e.Object == object &&
+			e.Username == username && now.Sub(e.Updated) < logGroupWindow {
+			e.Count++
+			e.Updated = now
+			e.ClientInfo = clientInfo
+			return
+		}
+	}
+
+	e := &LogEntry{
+		ID:         l.nextID,
+		Count:      1,
+		Reason:     reason,
+		Context:    context,
+		Object:     object,
+		Username:   username,
+		ClientInfo: clientInfo,
+		Created:    now,
+		Updated:    now,
+	}
+	l.nextID++
+	l.entries = append([]*LogEntry{e}, l.entries...)
+	if len(l.entries) > logMaxLen {
+		l.entries = l.entries[:logMaxLen]
+	}
+}
+
+// Entries returns up to count entries, newest first. A negative count returns every entry.
+func (l *Log) Entries(count int) []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if count < 0 || count > len(l.entries) {
+		count = len(l.entries)
+	}
+	out := make([]LogEntry, count)
+	for i := 0; i < count; i++ {
+		out[i] = *l.entries[i]
+	}
+	return out
+}
+
+// Reset removes every entry.
+func (l *Log) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = nil
+}
@@ -0,0 +1,372 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/blvckbill/redis-from-scratch/internal/glob"
+)
+
+/*
+User is a single ACL user: whether it may log in, with which passwords, and which
+commands, keys and pub/sub channels it may use.
+Users are only changed through the ACL that owns them.
+*/
+type User struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords []string // sha256 hex digests, in the order they were added
+
+	allowed    map[string]bool // commands that may run with any subcommand
+	allowedSub map[string]bool // "command|subcommand" pairs allowed on top of allowed
+	deniedSub  map[string]bool // "command|subcommand" pairs denied even though the command is allowed
+	cmdRules   []string        // command rules as applied, used to describe the user
+
+	keys     []string // glob patterns of the keys the user may access
+	channels []string // glob patterns of the channels the user may use
+}
+
+func newUser(name string) *User {
+	return &User{
+		name:       name,
+		allowed:    make(map[string]bool),
+		allowedSub: make(map[string]bool),
+		deniedSub:  make(map[string]bool),
+	}
+}
+
+// Name returns the name of the user.
+func (u *User) Name() string {
+	return u.name
+}
+
+// clone returns a deep copy, rules are applied to a copy so a failing SETUSER changes nothing.
+func (u *User) clone() *User {
+	c := &User{
+		name:       u.name,
+		enabled:    u.enabled,
+		nopass:     u.nopass,
+		passwords:  append([]string(nil), u.passwords...),
+		allowed:    make(map[string]bool, len(u.allowed)),
+		allowedSub: make(map[string]bool, len(u.allowedSub)),
+		deniedSub:  make(map[string]bool, len(u.deniedSub)),
+		cmdRules:   append([]string(nil), u.cmdRules...),
+		keys:       append([]string(nil), u.keys...),
+		channels:   append([]string(nil), u.channels...),
+	}
+	for k, v := range u.allowed {
+		c.allowed[k] = v
+	}
+	for k, v := range u.allowedSub {
+		c.allowedSub[k] = v
+	}
+	for k, v := range u.deniedSub {
+		c.deniedSub[k] = v
+	}
+	return c
+}
+
+// hashPassword returns the hex sha256 digest ACLs store instead of the password.
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+applyRule applies a single ACL rule to the user, in the syntax of ACL SETUSER:
+
+	on, off                   enable or disable the user
+	nopass, resetpass         allow any password, or forget every password
+	>password, <password      add or remove a password
+	#hash, !hash              add or remove the sha256 hex digest of a password
+	~pattern, allkeys         allow keys matching a pattern, ~* for every key
+	resetkeys                 forget every key pattern
+	&pattern, allchannels     allow pub/sub channels matching a pattern
+	resetchannels             forget every channel pattern
+	+command, -command        allow or deny a command, or a command|subcommand
+	+@category, -@category    allow or deny every command in a category
+	allcommands, nocommands   aliases for +@all and -@all
+	reset                     resetpass resetkeys resetchannels nocommands off
+*/
+func (a *ACL) applyRule(u *User, rule string) error {
+	lower := strings.ToLower(rule)
+	switch lower {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+		return nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+		return nil
+	case "allkeys":
+		u.keys = []string{"*"}
+		return nil
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allchannels":
+		u.channels = []string{"*"}
+		return nil
+	case "resetchannels":
+		u.channels = nil
+		return nil
+	case "allcommands":
+		return a.applyRule(u, "+@all")
+	case "nocommands":
+		return a.applyRule(u, "-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "nocommands", "off"} {
+			a.applyRule(u, r)
+		}
+		return nil
+	}
+
+	if rule == "" {
+		return errSyntax
+	}
+	switch rule[0] {
+	case '>':
+		h := hashPassword(rule[1:])
+		u.nopass = false
+		if !contains(u.passwords, h) {
+			u.passwords = append(u.passwords, h)
+		}
+		return nil
+
+	case '<':
+		return u.removePassword(hashPassword(rule[1:]))
+
+	case '#':
+		h := strings.ToLower(rule[1:])
+		if !isHexDigest(h) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.nopass = false
+		if !contains(u.passwords, h) {
+			u.passwords = append(u.passwords, h)
+		}
+		return nil
+
+	case '!':
+		return u.removePassword(strings.ToLower(rule[1:]))
+
+	case '~':
+		if rule[1:] == "*" {
+			u.keys = []string{"*"}
+		} else if !contains(u.keys, "*") && !contains(u.keys, rule[1:]) {
+			u.keys = append(u.keys, rule[1:])
+		}
+		return nil
+
+	case '&':
+		if rule[1:] == "*" {
+			u.channels = []string{"*"}
+		} else if !contains(u.channels, "*") && !contains(u.channels, rule[1:]) {
+			u.channels = append(u.channels, rule[1:])
+		}
+		return nil
+
+	case '+', '-':
+		return a.applyCommandRule(u, rule[0] == '+', lower[1:])
+	}
+	return errSyntax
+}
+
+// applyCommandRule handles +command, -command, +command|sub, -command|sub, +@category and -@category.
+func (a *ACL) applyCommandRule(u *User, allow bool, name string) error {
+	var cmds []string
+	switch {
+	case strings.HasPrefix(name, "@"):
+		var ok bool
+		cmds, ok = a.categories[name[1:]]
+		if !ok {
+			return errUnknownCommand
+		}
+		if name == "@all" {
+			// everything before +@all or -@all is irrelevant now
+			u.cmdRules = nil
+			u.allowedSub = make(map[string]bool)
+			u.deniedSub = make(map[string]bool)
+		}
+
+	case strings.Contains(name, "|"):
+		cmd, sub, _ := strings.Cut(name, "|")
+		if !a.containers[cmd] || sub == "" {
+			return errUnknownCommand
+		}
+		u.setSubRule(name, allow)
+		u.cmdRules = append(u.cmdRules, ruleSign(allow)+name)
+		return nil
+
+	default:
+		if _, ok := a.commands[name]; !ok {
+			return errUnknownCommand
+		}
+		cmds = []string{name}
+	}
+
+	for _, cmd := range cmds {
+		if strings.Contains(cmd, "|") {
+			// a category holding a single subcommand, like acl|setuser in @admin
+			u.setSubRule(cmd, allow)
+			continue
+		}
+		u.allowed[cmd] = allow
+		// a rule for the whole command overrides earlier subcommand rules
+		for pair := range u.allowedSub {
+			if strings.HasPrefix(pair, cmd+"|") {
+				delete(u.allowedSub, pair)
+			}
+		}
+		for pair := range u.deniedSub {
+			if strings.HasPrefix(pair, cmd+"|") {
+				delete(u.deniedSub, pair)
+			}
+		}
+	}
+	u.cmdRules = append(u.cmdRules, ruleSign(allow)+name)
+	return nil
+}
+
+// setSubRule allows or denies a command|subcommand pair, replacing any earlier rule for it.
+func (u *User) setSubRule(pair string, allow bool) {
+	if allow {
+		u.allowedSub[pair] = true
+		delete(u.deniedSub, pair)
+	} else {
+		u.deniedSub[pair] = true
+		delete(u.allowedSub, pair)
+	}
+}
+
+func (u *User) removePassword(h string) error {
+	for i, p := range u.passwords {
+		if p == h {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("no such password")
+}
+
+// canRun reports whether the user may run command, with sub being its subcommand for container commands.
+func (u *User) canRun(command, sub string) bool {
+	if sub != "" {
+		pair := command + "|" + sub
+		if u.deniedSub[pair] {
+			return false
+		}
+		if u.allowedSub[pair] {
+			return true
+		}
+	}
+	return u.allowed[command]
+}
+
+func (u *User) canAccessKey(key string) bool {
+	return matchAny(u.keys, key)
+}
+
+func (u *User) canAccessChannel(channel string) bool {
+	return matchAny(u.channels, channel)
+}
+
+// flags returns the flags ACL GETUSER reports for the user.
+func (u *User) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// commandsDescription describes the user's command permissions the way ACL LIST does.
+func (u *User) commandsDescription() string {
+	if len(u.cmdRules) == 0 {
+		return "-@all"
+	}
+	return strings.Join(u.cmdRules, " ")
+}
+
+func (u *User) keysDescription() string {
+	return patternsDescription("~", u.keys, "resetkeys")
+}
+
+func (u *User) channelsDescription() string {
+	return patternsDescription("&", u.channels, "resetchannels")
+}
+
+// describe returns the rules that recreate the user, as shown by ACL LIST and written by ACL SAVE.
+func (u *User) describe() string {
+	parts := []string{"user", u.name}
+	if u.enabled {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, p := range u.passwords {
+		parts = append(parts, "#"+p)
+	}
+	parts = append(parts, u.keysDescription(), u.channelsDescription(), u.commandsDescription())
+	return strings.Join(parts, " ")
+}
+
+func patternsDescription(prefix string, patterns []string, none string) string {
+	if len(patterns) == 0 {
+		return none
+	}
+	out := make([]string, len(patterns))
+	for i, p := range patterns {
+		out[i] = prefix + p
+	}
+	return strings.Join(out, " ")
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if p == "*" || glob.Match(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func ruleSign(allow bool) string {
+	if allow {
+		return "+"
+	}
+	return "-"
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isHexDigest(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,452 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blvckbill/redis-from-scratch/internal/glob"
+)
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindInt
+	kindBool
+	kindEnum
+	kindMemory // a byte count that accepts units such as 512mb or 1gb
+	kindOctal  // an integer written in octal, such as a file mode
+)
+
+/*
+param describes a single configuration parameter.
+def is the default value, min and max bound int and memory values, enum lists the
+accepted values of an enum. Immutable parameters can only be set at startup.
+*/
+type param struct {
+	name      string
+	kind      kind
+	def       string
+	min, max  int64
+	enum      []string
+	immutable bool
+}
+
+// params is every parameter the server understands.
+var params = []*param{
+	{name: "bind", kind: kindString, def: "127.0.0.1", immutable: true},
+	{name: "port", kind: kindInt, def: "6369", min: 0, max: 65535, immutable: true},
+
+	{name: "unixsocket", kind: kindString, def: "", immutable: true},
+	{name: "unixsocketperm", kind: kindOctal, def: "0", min: 0, max: 0777, immutable: true},
+
+	{name: "tls-port", kind: kindInt, def: "0", min: 0, max: 65535, immutable: true},
+	{name: "tls-cert-file", kind: kindString, def: "", immutable: true},
+	{name: "tls-key-file", kind: kindString, def: "", immutable: true},
+	{name: "tls-ca-cert-file", kind: kindString, def: "", immutable: true},
+	{name: "tls-auth-clients", kind: kindEnum, def: "yes", enum: []string{"yes", "no", "optional"}, immutable: true},
+	{name: "tls-min-version", kind: kindEnum, def: "tlsv1.2", enum: []string{"tlsv1.2", "tlsv1.3"}, immutable: true},
+
+	{name: "appendonly", kind: kindBool, def: "yes", immutable: true},
+	{name: "appendfilename", kind: kindString, def: "appendonly.aof", immutable: true},
+	{name: "appendfsync", kind: kindEnum, def: "everysec", enum: []string{"always", "everysec", "no"}},
+
+	{name: "maxclients", kind: kindInt, def: "10000", min: 1, max: 1 << 31},
+	{name: "timeout", kind: kindInt, def: "0", min: 0, max: 1 << 31},
+	{name: "tcp-keepalive", kind: kindInt, def: "300", min: 0, max: 1 << 31},
+
+	{name: "requirepass", kind: kindString, def: ""},
+	{name: "aclfile", kind: kindString, def: "", immutable: true},
+	{name: "shutdown-timeout", kind: kindInt, def: "10", min: 0, max: 1 << 31},
+
+	{name: "list-max-listpack-size", kind: kindInt, def: "-2", min: -5, max: 1 << 31},
+	{name: "hash-max-listpack-entries", kind: kindInt, def: "128", min: 0, max: 1 << 31},
+	{name: "hash-max-listpack-value", kind: kindInt, def: "64", min: 0, max: 1 << 31},
+	{name: "set-max-intset-entries", kind: kindInt, def: "512", min: 0, max: 1 << 31},
+
+	{name: "proto-max-bulk-len", kind: kindMemory, def: "512mb", min: 1024 * 1024, max: 1 << 62},
+	{name: "proto-max-multibulk-len", kind: kindInt, def: "1048576", min: 1, max: 1 << 31},
+	{name: "client-query-buffer-limit", kind: kindMemory, def: "1gb", min: 1024 * 1024, max: 1 << 62},
+}
+
+func lookupParam(name string) (*param, bool) {
+	name = strings.ToLower(name)
+	for _, p := range params {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+/*
+Config holds the server configuration: the defaults, overridden by the config file,
+overridden by the command line, overridden at runtime by CONFIG SET.
+Values are kept in their canonical string form, e.g. yes/no for booleans and a plain
+byte count for memory values.
+*/
+type Config struct {
+	mu     sync.RWMutex
+	file   string // absolute path of the config file, empty if there is none
+	values map[string]string
+}
+
+// Default returns a configuration with every parameter at its default value.
+func Default() *Config {
+	c := &Config{
+		values: make(map[string]string),
+	}
+	for _, p := range params {
+		c.values[p.name], _ = p.normalize(p.def)
+	}
+	return c
+}
+
+/*
+Load builds a configuration from command line arguments in the redis-server style:
+an optional path to a config file followed by --name value overrides.
+
+	goredis /etc/goredis.conf --port 6380 --appendfsync always
+*/
+func Load(args []string) (*Config, error) {
+	c := Default()
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if err := c.loadFile(path); err != nil {
+			return nil, err
+		}
+		c.file = path
+		args = args[1:]
+	}
+
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") {
+			return nil, fmt.Errorf("unexpected argument '%s', options must start with --", args[i])
+		}
+		name := args[i][2:]
+		// everything up to the next option is the value
+		var value []string
+		for i+1 < len(args) && !strings.HasPrefix(args[i+1], "--") {
+			value = append(value, args[i+1])
+			i++
+		}
+		if err := c.load(name, strings.Join(value, " ")); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// loadFile applies every directive in a redis.conf style file.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		name, value, ok := parseLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		if err := c.load(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineno, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseLine splits a config file line into its directive and value, skipping blanks and comments.
+func parseLine(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	fields := strings.Fields(line)
+	value := strings.Join(fields[1:], " ")
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	return strings.ToLower(fields[0]), value, true
+}
+
+// File returns the path of the config file the configuration was loaded from, if any.
+func (c *Config) File() string {
+	return c.file
+}
+
+// String returns the value of a parameter.
+func (c *Config) String(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.values[name]
+}
+
+// Int returns the value of an int or memory parameter.
+func (c *Config) Int(name string) int64 {
+	n, _ := strconv.ParseInt(c.String(name), 10, 64)
+	return n
+}
+
+// Octal returns the value of an octal parameter.
+func (c *Config) Octal(name string) int64 {
+	n, _ := strconv.ParseInt(c.String(name), 8, 64)
+	return n
+}
+
+// Bool returns the value of a bool parameter.
+func (c *Config) Bool(name string) bool {
+	return c.String(name) == "yes"
+}
+
+// Get returns every parameter whose name matches one of the glob patterns, as name/value pairs sorted by name.
+func (c *Config) Get(patterns ...string) [][2]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var out [][2]string
+	for _, p := range params {
+		for _, pattern := range patterns {
+			if glob.MatchNoCase(pattern, p.name) {
+				out = append(out, [2]string{p.name, c.values[p.name]})
+				break
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i][0] < out[j][0] })
+	return out
+}
+
+/*
+Set changes one or more parameters at runtime, pairs holds alternating names and values.
+Either every value is applied or, if any of them is invalid or immutable, none is.
+*/
+func (c *Config) Set(pairs ...string) error {
+	if len(pairs)%2 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	updated := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		p, ok := lookupParam(pairs[i])
+		if !ok {
+			return &UnknownError{Name: pairs[i]}
+		}
+		if p.immutable {
+			return &SetError{Name: p.name, Msg: "can't set immutable config"}
+		}
+		if _, dup := updated[p.name]; dup {
+			return &SetError{Name: p.name, Msg: "duplicate parameter"}
+		}
+		v, err := p.normalize(pairs[i+1])
+		if err != nil {
+			return &SetError{Name: p.name, Msg: err.Error()}
+		}
+		updated[p.name] = v
+	}
+
+	for name, v := range updated {
+		c.values[name] = v
+	}
+	return nil
+}
+
+// load applies a single parameter at startup, when immutable parameters may still be set.
+func (c *Config) load(name, value string) error {
+	p, ok := lookupParam(name)
+	if !ok {
+		return fmt.Errorf("unknown option '%s'", name)
+	}
+	v, err := p.normalize(value)
+	if err != nil {
+		return fmt.Errorf("bad value for '%s': %w", p.name, err)
+	}
+
+	c.mu.Lock()
+	c.values[p.name] = v
+	c.mu.Unlock()
+	return nil
+}
+
+/*
+Rewrite writes the current configuration back to the file it was loaded from.
+Lines for known parameters are updated in place, parameters that differ from their
+default but are missing from the file are appended and everything else, comments
+included, is kept as it is.
+*/
+func (c *Config) Rewrite() error {
+	if c.file == "" {
+		return errors.New("The server is running without a config file")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, err := os.ReadFile(c.file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	written := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line == "" && len(lines) == 0 {
+			continue
+		}
+		name, _, ok := parseLine(line)
+		if !ok {
+			lines = append(lines, line)
+			continue
+		}
+		if _, known := lookupParam(name); !known {
+			lines = append(lines, line)
+			continue
+		}
+		// only the first occurrence of a parameter survives a rewrite
+		if written[name] {
+			continue
+		}
+		lines = append(lines, formatLine(name, c.values[name]))
+		written[name] = true
+	}
+
+	for _, p := range params {
+		if written[p.name] {
+			continue
+		}
+		def, _ := p.normalize(p.def)
+		if c.values[p.name] != def {
+			lines = append(lines, formatLine(p.name, c.values[p.name]))
+		}
+	}
+
+	// write to a temporary file first so a crash never leaves a half written config
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+func formatLine(name, value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\"") {
+		value = strconv.Quote(value)
+	}
+	return name + " " + value
+}
+
+// normalize validates value for the parameter and returns it in canonical form.
+func (p *param) normalize(value string) (string, error) {
+	switch p.kind {
+	case kindInt, kindMemory:
+		var n int64
+		var err error
+		if p.kind == kindMemory {
+			n, err = ParseMemory(value)
+		} else {
+			n, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return "", errors.New("argument couldn't be parsed into an integer")
+		}
+		if n < p.min || n > p.max {
+			return "", fmt.Errorf("argument must be between %d and %d inclusive", p.min, p.max)
+		}
+		return strconv.FormatInt(n, 10), nil
+
+	case kindOctal:
+		n, err := strconv.ParseInt(value, 8, 64)
+		if err != nil {
+			return "", errors.New("argument couldn't be parsed into an octal integer")
+		}
+		if n < p.min || n > p.max {
+			return "", fmt.Errorf("argument must be between %o and %o inclusive", p.min, p.max)
+		}
+		return strconv.FormatInt(n, 8), nil
+
+	case kindBool:
+		switch strings.ToLower(value) {
+		case "yes":
+			return "yes", nil
+		case "no":
+			return "no", nil
+		}
+		return "", errors.New("argument must be 'yes' or 'no'")
+
+	case kindEnum:
+		v := strings.ToLower(value)
+		for _, e := range p.enum {
+			if v == e {
+				return v, nil
+			}
+		}
+		return "", fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(p.enum, ", "))
+	}
+	return value, nil
+}
+
+/*
+ParseMemory parses a byte count with an optional unit the way redis.conf does:
+k, m and g are powers of 1000 while kb, mb and gb are powers of 1024.
+*/
+func ParseMemory(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	mul := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mul, nil
+}
+
+// UnknownError is returned by Set for a parameter the server does not know.
+type UnknownError struct {
+	Name string
+}
+
+func (e *UnknownError) Error() string {
+	return "Unknown option or number of arguments for CONFIG SET - '" + e.Name + "'"
+}
+
+// SetError is returned by Set when a parameter cannot be set to the requested value.
+type SetError struct {
+	Name string
+	Msg  string
+}
+
+func (e *SetError) Error() string {
+	return "CONFIG SET failed (possibly related to argument '" + e.Name + "') - " + e.Msg
+}
@@ -0,0 +1,134 @@
+package glob
+
+/*
+Match reports whether s matches the Redis style glob pattern.
+The pattern supports:
+  - * matches any sequence of characters, including none
+  - ? matches a single character
+  - [abc], [^abc] and [a-z] match one character from, or not from, a set or range
+  - \x matches the character x literally
+*/
+func Match(pattern, s string) bool {
+	return match(pattern, s, false)
+}
+
+// MatchNoCase is like Match but compares ASCII letters case-insensitively.
+func MatchNoCase(pattern, s string) bool {
+	return match(pattern, s, true)
+}
+
+/*
+match walks pattern and s once, remembering only the last '*' seen. On a mismatch it goes back
+to that star and lets it swallow one more character of s; earlier stars never need to be
+retried, since whatever they would take the last one can take too. This keeps the time
+quadratic at worst, where trying every split for every star is exponential in the stars.
+*/
+func match(pattern, s string, nocase bool) bool {
+	p, i := 0, 0
+	star, starI := -1, 0
+	for p < len(pattern) || i < len(s) {
+		if p < len(pattern) {
+			switch c := pattern[p]; c {
+			case '*':
+				star, starI = p, i
+				p++
+				continue
+
+			case '?':
+				if i < len(s) {
+					p++
+					i++
+					continue
+				}
+
+			case '[':
+				if i < len(s) {
+					matched, rest := matchClass(pattern[p+1:], s[i], nocase)
+					if matched {
+						p = len(pattern) - len(rest)
+						i++
+						continue
+					}
+				}
+
+			default:
+				width := 1
+				if c == '\\' && p+1 < len(pattern) {
+					c = pattern[p+1]
+					width = 2
+				}
+				if i < len(s) && equal(c, s[i], nocase) {
+					p += width
+					i++
+					continue
+				}
+			}
+		}
+		if star < 0 || starI == len(s) {
+			return false
+		}
+		starI++
+		p, i = star+1, starI
+	}
+	return true
+}
+
+// matchClass matches c against the character class at the start of pattern, which follows the opening '['.
+// It returns whether c matched and the pattern left after the closing ']'.
+func matchClass(pattern string, c byte, nocase bool) (bool, string) {
+	not := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		not = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			if equal(pattern[1], c, nocase) {
+				matched = true
+			}
+			pattern = pattern[2:]
+
+		case len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			cc := c
+			if nocase {
+				lo, hi, cc = lower(lo), lower(hi), lower(c)
+			}
+			if cc >= lo && cc <= hi {
+				matched = true
+			}
+			pattern = pattern[3:]
+
+		default:
+			if equal(pattern[0], c, nocase) {
+				matched = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	// skip the closing bracket, an unterminated class runs to the end of the pattern
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return matched != not, pattern
+}
+
+func equal(a, b byte, nocase bool) bool {
+	if nocase {
+		return lower(a) == lower(b)
+	}
+	return a == b
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
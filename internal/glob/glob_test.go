@@ -0,0 +1,71 @@
+package glob
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:42", true},
+		{"user:*", "users:42", false},
+		{"*:42", "user:42", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"*a*a*b", "aaaab", true},
+		{"*a*a*b", "aaaa", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`a\`, `a\`, true},
+		{`[\]]`, "]", true},
+		{"[abc", "b", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMatchNoCase(t *testing.T) {
+	if !MatchNoCase("MAX*", "maxclients") {
+		t.Error("MatchNoCase(MAX*, maxclients) = false")
+	}
+	if !MatchNoCase("[A-C]x", "bX") {
+		t.Error("MatchNoCase([A-C]x, bX) = false")
+	}
+	if Match("MAX*", "maxclients") {
+		t.Error("Match(MAX*, maxclients) = true")
+	}
+}
+
+func TestMatchManyStars(t *testing.T) {
+	// every extra star used to multiply the work, this took minutes before
+	pattern := strings.Repeat("*a", 20) + "*b"
+	s := strings.Repeat("a", 5000)
+
+	start := time.Now()
+	if Match(pattern, s) {
+		t.Fatal("matched a string without b")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("took %v", d)
+	}
+}
@@ -0,0 +1,489 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+const (
+	// maxLineSize bounds a single header or inline command line, like PROTO_INLINE_MAX_SIZE in Redis.
+	maxLineSize = 64 * 1024
+	// maxArenaSize is the largest argument buffer kept around between commands,
+	// anything bigger is released once the next command is read.
+	maxArenaSize = 64 * 1024
+)
+
+/*
+Limits bounds what a Reader accepts from a peer, matching the Redis settings of the same name.
+A zero value disables that limit.
+*/
+type Limits struct {
+	MaxBulkLen       int // proto-max-bulk-len, the largest single bulk string
+	MaxMultibulkLen  int // the most elements a request array may announce
+	QueryBufferLimit int // client-query-buffer-limit, the most bytes a single request may take
+}
+
+// DefaultLimits are the limits Redis ships with.
+var DefaultLimits = Limits{
+	MaxBulkLen:       512 * 1024 * 1024,
+	MaxMultibulkLen:  1024 * 1024,
+	QueryBufferLimit: 1024 * 1024 * 1024,
+}
+
+/*
+Reader reads RESP values from a stream through a bufio.Reader.
+Values are parsed as the bytes arrive, so a large bulk string is read straight into its final
+buffer instead of being re-scanned every time more of it shows up.
+*/
+type Reader struct {
+	rd     *bufio.Reader
+	src    *source
+	offset int // bytes consumed from the stream so far
+	limits Limits
+
+	line  []byte   // holds lines that do not fit in the bufio buffer
+	arena []byte   // backing storage for the arguments returned by ReadCommand
+	spans []int    // start and end of every argument in arena
+	args  [][]byte // reused slice returned by ReadCommand
+}
+
+func NewReader(rd io.Reader) *Reader {
+	src := &source{rd: rd}
+	return &Reader{
+		rd:  bufio.NewReaderSize(src, 16*1024),
+		src: src,
+	}
+}
+
+// source is the stream behind the bufio.Reader, along with the bytes Fill read ahead of it.
+type source struct {
+	rd   io.Reader
+	held []byte
+}
+
+func (s *source) Read(p []byte) (int, error) {
+	if len(s.held) == 0 {
+		return s.rd.Read(p)
+	}
+	n := copy(p, s.held)
+	s.held = s.held[n:]
+	if len(s.held) == 0 {
+		s.held = nil
+	}
+	return n, nil
+}
+
+// SetLimits bounds the size of the values the Reader accepts, by default nothing is bounded.
+func (r *Reader) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
+// Offset returns the number of bytes consumed from the stream so far.
+func (r *Reader) Offset() int {
+	return r.offset
+}
+
+// Buffered returns the number of bytes that have been read from the stream but not parsed yet.
+func (r *Reader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+/*
+Fill waits until more bytes arrive from the stream without consuming any, and returns the
+stream's error if none do. It can be called over and over while the caller is not reading:
+once the buffer is full the bytes are held until they are read, up to the query buffer limit.
+*/
+func (r *Reader) Fill() error {
+	if n := r.rd.Buffered(); n < r.rd.Size() {
+		_, err := r.rd.Peek(n + 1)
+		return err
+	}
+	held := len(r.src.held)
+	if r.overLimit(r.rd.Buffered()+held, r.limits.QueryBufferLimit) {
+		return &ProtocolError{Offset: r.offset, Msg: "query buffer limit exceeded"}
+	}
+	r.src.held = slices.Grow(r.src.held, r.rd.Size())
+	n, err := r.src.rd.Read(r.src.held[held:cap(r.src.held)])
+	r.src.held = r.src.held[:held+n]
+	return err
+}
+
+/*
+ReadCommand reads the next client request, either a multibulk array of bulk strings or an
+inline command, and returns its arguments. The returned slices point into a buffer owned by
+the Reader and are only valid until the next call to ReadCommand.
+An empty request returns no arguments.
+It returns io.EOF if the stream ended cleanly between commands, io.ErrUnexpectedEOF if it ended
+in the middle of one and a *ProtocolError if the input is malformed.
+*/
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	b, err := r.rd.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != '*' {
+		return r.readInline()
+	}
+
+	start := r.offset
+	_, line, err := r.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(string(line))
+	if err != nil || r.overLimit(count, r.limits.MaxMultibulkLen) {
+		return nil, &ProtocolError{Offset: start, Msg: "invalid multibulk length"}
+	}
+
+	// drop a buffer that grew for a large argument so the memory is released
+	if cap(r.arena) > maxArenaSize {
+		r.arena = nil
+	}
+	r.arena = r.arena[:0]
+	r.spans = r.spans[:0]
+	r.args = r.args[:0]
+
+	for i := 0; i < count; i++ {
+		pos := r.offset
+		t, line, err := r.readHeader()
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if t != '$' {
+			return nil, &ProtocolError{Offset: pos, Msg: fmt.Sprintf("expected '$', got '%c'", t)}
+		}
+		length, err := strconv.Atoi(string(line))
+		if err != nil || length < 0 || r.overLimit(length, r.limits.MaxBulkLen) {
+			return nil, &ProtocolError{Offset: pos, Msg: "invalid bulk length"}
+		}
+		if r.overLimit(r.offset-start+length, r.limits.QueryBufferLimit) {
+			return nil, &ProtocolError{Offset: start, Msg: "query buffer limit exceeded"}
+		}
+
+		// read the payload and its CRLF straight into the arena
+		off := len(r.arena)
+		r.arena = slices.Grow(r.arena, length+2)[:off+length+2]
+		if err := r.readFull(r.arena[off:]); err != nil {
+			return nil, err
+		}
+		if r.arena[off+length] != '\r' || r.arena[off+length+1] != '\n' {
+			return nil, &ProtocolError{Offset: r.offset - 2, Msg: "expected CRLF after bulk data"}
+		}
+		r.arena = r.arena[:off+length]
+		r.spans = append(r.spans, off, off+length)
+	}
+
+	for i := 0; i < len(r.spans); i += 2 {
+		r.args = append(r.args, r.arena[r.spans[i]:r.spans[i+1]])
+	}
+	return r.args, nil
+}
+
+/*
+readInline reads a command in the inline format used by telnet and nc sessions, where the
+arguments are separated by spaces and the line is terminated by \r\n or just \n.
+*/
+func (r *Reader) readInline() ([][]byte, error) {
+	start := r.offset
+	line, err := r.readLine("too big inline request")
+	if err != nil {
+		return nil, err
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+
+	args, ok := splitArgs(string(line))
+	if !ok {
+		return nil, &ProtocolError{Offset: start, Msg: "unbalanced quotes in request"}
+	}
+
+	r.args = r.args[:0]
+	for _, arg := range args {
+		r.args = append(r.args, []byte(arg))
+	}
+	return r.args, nil
+}
+
+/*
+ReadValue reads the next RESP2 or RESP3 value of any type.
+Anything that does not start with a known type byte is read as an inline command and
+returned as an Array of bulk strings.
+Errors are reported the same way as in ReadCommand.
+*/
+func (r *Reader) ReadValue() (*Resp, error) {
+	return r.readValue(false)
+}
+
+// readValue implements ReadValue. Inline commands are only valid at the top level, inside an aggregate a value needs a type byte.
+func (r *Reader) readValue(nested bool) (*Resp, error) {
+	b, err := r.rd.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	start := r.offset
+	switch b[0] {
+
+	case '+', '-', ':', '_', ',', '#', '(':
+		t, line, err := r.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		return parseSimple(t, string(line), start)
+
+	case '$', '!', '=':
+		t, line, err := r.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if t == '$' && string(line) == "-1" {
+			return &Resp{
+				Type: BulkString,
+				Str:  nil,
+			}, nil
+		}
+		length, err := strconv.Atoi(string(line))
+		if err != nil || length < 0 || r.overLimit(length, r.limits.MaxBulkLen) {
+			return nil, &ProtocolError{Offset: start, Msg: "invalid bulk length"}
+		}
+		data := make([]byte, length+2)
+		if err := r.readFull(data); err != nil {
+			return nil, err
+		}
+		if data[length] != '\r' || data[length+1] != '\n' {
+			return nil, &ProtocolError{Offset: r.offset - 2, Msg: "expected CRLF after bulk data"}
+		}
+		return parseBlob(t, string(data[:length]), start)
+
+	case '*', '~', '>', '%', '|':
+		t, line, err := r.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if t == '*' && string(line) == "-1" {
+			return &Resp{
+				Type:  Array,
+				Array: nil,
+			}, nil
+		}
+		count, err := strconv.Atoi(string(line))
+		if err != nil || count < 0 || r.overLimit(count, r.limits.MaxMultibulkLen) {
+			return nil, &ProtocolError{Offset: start, Msg: "invalid multibulk length"}
+		}
+		if t == '%' || t == '|' {
+			// every entry is a key followed by a value
+			count *= 2
+		}
+
+		// don't trust the announced count for the allocation, the elements may never arrive
+		items := make([]*Resp, 0, min(count, 1024))
+		for i := 0; i < count; i++ {
+			item, err := r.readValue(true)
+			if err != nil {
+				return nil, unexpected(err)
+			}
+			items = append(items, item)
+		}
+
+		switch t {
+		case '~':
+			return &Resp{Type: Set, Array: items}, nil
+		case '>':
+			return &Resp{Type: Push, Array: items}, nil
+		case '%':
+			return &Resp{Type: Map, Array: items}, nil
+		case '|':
+			// attributes annotate the value that follows them, so read both and
+			// hand back the annotated value
+			value, err := r.readValue(true)
+			if err != nil {
+				return nil, unexpected(err)
+			}
+			value.Attrs = items
+			return value, nil
+		}
+		return &Resp{Type: Array, Array: items}, nil
+	}
+
+	if nested {
+		return nil, &ProtocolError{Offset: start, Msg: "unknown type"}
+	}
+	args, err := r.readInline()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*Resp, 0, len(args))
+	for _, arg := range args {
+		a := string(arg)
+		items = append(items, &Resp{
+			Type: BulkString,
+			Str:  &a,
+		})
+	}
+	return &Resp{
+		Type:  Array,
+		Array: items,
+	}, nil
+}
+
+/*
+readLine reads up to and including the next \n and returns the line without the \n.
+The returned slice is only valid until the next read.
+tooLong is the protocol error reported if the line is longer than maxLineSize.
+*/
+func (r *Reader) readLine(tooLong string) ([]byte, error) {
+	start := r.offset
+	line, err := r.rd.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// the line does not fit in the bufio buffer, collect it piece by piece
+		r.line = append(r.line[:0], line...)
+		for err == bufio.ErrBufferFull {
+			if len(r.line) > maxLineSize {
+				return nil, &ProtocolError{Offset: start, Msg: tooLong}
+			}
+			line, err = r.rd.ReadSlice('\n')
+			r.line = append(r.line, line...)
+		}
+		line = r.line
+	}
+	r.offset += len(line)
+
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if len(line) > maxLineSize {
+		return nil, &ProtocolError{Offset: start, Msg: tooLong}
+	}
+	return line[:len(line)-1], nil
+}
+
+// readHeader reads a CRLF terminated line and splits it into its type byte and the rest of the line.
+func (r *Reader) readHeader() (byte, []byte, error) {
+	start := r.offset
+	line, err := r.readLine("too big header")
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(line) < 2 || line[len(line)-1] != '\r' {
+		return 0, nil, &ProtocolError{Offset: start, Msg: "expected CRLF after header"}
+	}
+	return line[0], line[1 : len(line)-1], nil
+}
+
+// overLimit reports whether n is above limit, a zero limit is never exceeded.
+func (r *Reader) overLimit(n, limit int) bool {
+	return limit > 0 && n > limit
+}
+
+// readFull fills buf from the stream, a stream that ends early is an unexpected EOF.
+func (r *Reader) readFull(buf []byte) error {
+	n, err := io.ReadFull(r.rd, buf)
+	r.offset += n
+	return unexpected(err)
+}
+
+// unexpected turns io.EOF into io.ErrUnexpectedEOF for reads that happen in the middle of a value.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// parseSimple builds a value from the payload of a single line type.
+func parseSimple(t byte, line string, offset int) (*Resp, error) {
+	switch t {
+
+	case '+': // Simple String
+		return &Resp{
+			Type: SimpleString,
+			Str:  &line,
+		}, nil
+
+	case '-': // Error
+		return &Resp{
+			Type: Error,
+			Str:  &line,
+		}, nil
+
+	case ':': // Integer
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, &ProtocolError{Offset: offset, Msg: "invalid integer"}
+		}
+		return &Resp{
+			Type: Integer,
+			Int:  n,
+		}, nil
+
+	case '_': // Null
+		if line != "" {
+			return nil, &ProtocolError{Offset: offset, Msg: "invalid null"}
+		}
+		return &Resp{Type: Null}, nil
+
+	case ',': // Double
+		f, err := parseDouble(line)
+		if err != nil {
+			return nil, &ProtocolError{Offset: offset, Msg: "invalid double"}
+		}
+		return &Resp{
+			Type:   Double,
+			Double: f,
+		}, nil
+
+	case '#': // Boolean
+		if line != "t" && line != "f" {
+			return nil, &ProtocolError{Offset: offset, Msg: "invalid boolean"}
+		}
+		return &Resp{
+			Type: Boolean,
+			Bool: line == "t",
+		}, nil
+
+	case '(': // Big Number
+		if !isBigNumber(line) {
+			return nil, &ProtocolError{Offset: offset, Msg: "invalid big number"}
+		}
+		return &Resp{
+			Type: BigNumber,
+			Str:  &line,
+		}, nil
+	}
+	return nil, &ProtocolError{Offset: offset, Msg: fmt.Sprintf("unknown type '%c'", t)}
+}
+
+// parseBlob builds a value from the payload of a length prefixed type.
+func parseBlob(t byte, data string, offset int) (*Resp, error) {
+	switch t {
+
+	case '!': // Blob Error
+		return &Resp{
+			Type: BlobError,
+			Str:  &data,
+		}, nil
+
+	case '=': // Verbatim String
+		// verbatim strings are prefixed with a three letter format and a colon
+		if len(data) < 4 || data[3] != ':' {
+			return nil, &ProtocolError{Offset: offset, Msg: "invalid verbatim string format"}
+		}
+		text := data[4:]
+		return &Resp{
+			Type:   VerbatimString,
+			Str:    &text,
+			Format: data[:3],
+		}, nil
+	}
+	return &Resp{
+		Type: BulkString,
+		Str:  &data,
+	}, nil
+}
@@ -0,0 +1,168 @@
+package resp
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func readCommand(t *testing.T, input string, limits Limits) ([]string, error) {
+	t.Helper()
+	rd := NewReader(strings.NewReader(input))
+	rd.SetLimits(limits)
+	args, err := rd.ReadCommand()
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = string(a)
+	}
+	return out, err
+}
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name, input string
+		want        []string
+	}{
+		{"multibulk", "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nv\r\nx!\r\n", []string{"SET", "k", "v\r\nx!"}},
+		{"empty multibulk", "*0\r\n", []string{}},
+		{"inline", "SET k v\r\n", []string{"SET", "k", "v"}},
+		{"inline without CR", "PING\n", []string{"PING"}},
+		{"inline quotes", "SET \"a b\" 'c\\'d' \"\\x41\"\r\n", []string{"SET", "a b", "c'd", "A"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCommand(t, tt.input, DefaultLimits)
+			if err != nil {
+				t.Fatalf("ReadCommand(%q) error: %v", tt.input, err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Fatalf("ReadCommand(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCommandProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name, input string
+		limits      Limits
+		msg         string
+	}{
+		{"bad multibulk length", "*x\r\n", DefaultLimits, "invalid multibulk length"},
+		{"too many elements", "*5\r\n", Limits{MaxMultibulkLen: 4}, "invalid multibulk length"},
+		{"not a bulk string", "*1\r\n:1\r\n", DefaultLimits, "expected '$', got ':'"},
+		{"bad bulk length", "*1\r\n$-3\r\n", DefaultLimits, "invalid bulk length"},
+		{"bulk too long", "*1\r\n$10\r\n", Limits{MaxBulkLen: 9}, "invalid bulk length"},
+		{"query buffer limit", "*2\r\n$3\r\nGET\r\n$100\r\n", Limits{QueryBufferLimit: 64}, "query buffer limit exceeded"},
+		{"missing CRLF after data", "*1\r\n$3\r\nGETxx", DefaultLimits, "expected CRLF after bulk data"},
+		{"missing CRLF after header", "*1\n", DefaultLimits, "expected CRLF after header"},
+		{"unbalanced quotes", "SET \"k v\r\n", DefaultLimits, "unbalanced quotes in request"},
+		{"inline too long", strings.Repeat("a", maxLineSize+1) + "\r\n", DefaultLimits, "too big inline request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readCommand(t, tt.input, tt.limits)
+			var perr *ProtocolError
+			if !errors.As(err, &perr) {
+				t.Fatalf("ReadCommand(%.40q) error = %v, want a ProtocolError", tt.input, err)
+			}
+			if perr.Msg != tt.msg {
+				t.Fatalf("ReadCommand(%.40q) error = %q, want %q", tt.input, perr.Msg, tt.msg)
+			}
+		})
+	}
+}
+
+func TestReadCommandEOF(t *testing.T) {
+	if _, err := readCommand(t, "", DefaultLimits); err != io.EOF {
+		t.Errorf("empty stream: error = %v, want io.EOF", err)
+	}
+	for _, input := range []string{"*2\r\n$3\r\nGET\r\n", "*1\r\n$3\r\nGE"} {
+		if _, err := readCommand(t, input, DefaultLimits); err != io.ErrUnexpectedEOF {
+			t.Errorf("ReadCommand(%q) error = %v, want io.ErrUnexpectedEOF", input, err)
+		}
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	if _, _, err := Parser([]byte("$5\r\nhel")); err != ErrIncomplete {
+		t.Errorf("partial bulk: error = %v, want ErrIncomplete", err)
+	}
+	for input, msg := range map[string]string{
+		":12a\r\n":      "invalid integer",
+		"#x\r\n":        "invalid boolean",
+		",abc\r\n":      "invalid double",
+		"_1\r\n":        "invalid null",
+		"$-5\r\n":       "invalid bulk length",
+		"=3\r\nabc\r\n": "invalid verbatim string format",
+	} {
+		_, _, err := Parser([]byte(input))
+		var perr *ProtocolError
+		if !errors.As(err, &perr) || perr.Msg != msg {
+			t.Errorf("Parser(%q) error = %v, want %q", input, err, msg)
+		}
+	}
+}
+
+func TestReadValueInline(t *testing.T) {
+	v, err := NewReader(strings.NewReader("SET k v\r\n")).ReadValue()
+	if err != nil || v.Type != Array || len(v.Array) != 3 || *v.Array[2].Str != "v" {
+		t.Fatalf("top level inline: %+v, %v", v, err)
+	}
+
+	// inside an aggregate every value needs a type byte
+	for input, offset := range map[string]int{
+		"*1\r\nfoo\r\n":           4,
+		"*2\r\n:1\r\nfoo\r\n":     8,
+		"%1\r\n+k\r\nfoo\r\n":     8,
+		"|1\r\n+a\r\n:1\r\nx\r\n": 12,
+	} {
+		_, err := NewReader(strings.NewReader(input)).ReadValue()
+		var perr *ProtocolError
+		if !errors.As(err, &perr) || perr.Msg != "unknown type" || perr.Offset != offset {
+			t.Errorf("ReadValue(%q) error = %v, want unknown type at %d", input, err, offset)
+		}
+	}
+}
+
+func TestFill(t *testing.T) {
+	// more commands than fit in the read buffer, each read a few bytes at a time
+	var input strings.Builder
+	for range 4000 {
+		input.WriteString("*1\r\n$4\r\nPING\r\n")
+	}
+	rd := NewReader(iotest.HalfReader(strings.NewReader(input.String())))
+	rd.SetLimits(Limits{QueryBufferLimit: 64 * 1024})
+	for {
+		err := rd.Fill()
+		if err == io.EOF {
+			break
+		}
+		var perr *ProtocolError
+		if errors.As(err, &perr) {
+			t.Fatalf("Fill: %v", err)
+		}
+	}
+	for i := range 4000 {
+		args, err := rd.ReadCommand()
+		if err != nil || len(args) != 1 || string(args[0]) != "PING" {
+			t.Fatalf("command %d: %q, %v", i, args, err)
+		}
+	}
+	if _, err := rd.ReadCommand(); err != io.EOF {
+		t.Fatalf("got %v after the last command, want EOF", err)
+	}
+
+	rd = NewReader(strings.NewReader(input.String()))
+	rd.SetLimits(Limits{QueryBufferLimit: 32 * 1024})
+	var err error
+	for err == nil {
+		err = rd.Fill()
+	}
+	var perr *ProtocolError
+	if !errors.As(err, &perr) || perr.Msg != "query buffer limit exceeded" {
+		t.Fatalf("Fill past the query buffer limit: %v", err)
+	}
+}
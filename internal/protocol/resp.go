@@ -2,7 +2,11 @@ package resp
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"math"
 	"strconv"
+	"strings"
 )
 
 type Resptype int
@@ -13,134 +17,211 @@ const (
 	Integer
 	BulkString
 	Array
+
+	// RESP3 types
+	Null
+	Double
+	Boolean
+	BigNumber
+	VerbatimString
+	BlobError
+	Map
+	Set
+	Attribute
+	Push
 )
 
+/*
+Resp is a single RESP value.
+Map and Attribute values keep their entries in Array as flattened key/value pairs.
+Attrs holds the attribute pairs that were sent in front of this value, if any.
+*/
 type Resp struct {
-	Type  Resptype
-	Str   *string
-	Int   int64
-	Array []*Resp
+	Type   Resptype
+	Str    *string
+	Int    int64
+	Double float64
+	Bool   bool
+	Format string // three letter format of a VerbatimString, e.g. "txt"
+	Array  []*Resp
+	Attrs  []*Resp
+}
+
+// ErrIncomplete is returned by Parser when the buffer does not yet hold a complete value.
+var ErrIncomplete = errors.New("incomplete RESP value")
+
+/*
+ProtocolError is returned when the input can never become a valid value, no matter how
+many more bytes arrive. Offset is the position in the stream where the offending header
+or payload starts.
+*/
+type ProtocolError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.Msg
 }
 
 /*
-respToString is a helper function that converts a RESP object to a string if it is of type SimpleString or BulkString.
-It returns the string and a boolean indicating whether the conversion was successful.
+Parser parses a single RESP2 or RESP3 value from the start of buffer.
+It returns the value and the number of bytes consumed. If the buffer only holds part of a
+value the error is ErrIncomplete and the caller should read more; any other error is a
+*ProtocolError and the input should be rejected.
+Parser is a convenience for callers that already hold the bytes, streams should use a Reader.
 */
-func Parser(buffer []byte) (*Resp, int, bool) {
+func Parser(buffer []byte) (*Resp, int, error) {
 	if len(buffer) == 0 {
-		return nil, 0, false
+		return nil, 0, ErrIncomplete
 	}
-	// Determine the type of RESP message based on the first byte
-	switch buffer[0] {
-
-	case '+': // Simple String
-		idx := bytes.Index(buffer, []byte("\r\n"))
-		if idx == -1 {
-			return nil, 0, false
-		}
-		bytesConsumed := idx + 2
-		s := string(buffer[1:idx])
-		return &Resp{
-			Type: SimpleString,
-			Str:  &s,
-		}, bytesConsumed, true
-
-	case '-': // Error
-		idx := bytes.Index(buffer, []byte("\r\n"))
-		if idx == -1 {
-			return nil, 0, false
-		}
-		bytesConsumed := idx + 2
-		s := string(buffer[1:idx])
-		return &Resp{
-			Type: Error,
-			Str:  &s,
-		}, bytesConsumed, true
-
-	case ':': // Integer
-		idx := bytes.Index(buffer, []byte("\r\n"))
-		if idx == -1 {
-			return nil, 0, false
-		}
-		// Parse the integer value
-		n, err := strconv.ParseInt(string(buffer[1:idx]), 10, 64)
-		if err != nil {
-			return nil, 0, false
-		}
-		bytesConsumed := idx + 2
-		return &Resp{
-			Type: Integer,
-			Int:  n,
-		}, bytesConsumed, true
-
-	case '$': // Bulk String
-		idx := bytes.Index(buffer, []byte("\r\n"))
-		if idx == -1 {
-			return nil, 0, false
+	rd := NewReader(bytes.NewReader(buffer))
+	value, err := rd.ReadValue()
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, ErrIncomplete
 		}
+		return nil, 0, err
+	}
+	return value, rd.Offset(), nil
+}
 
-		length, err := strconv.Atoi(string(buffer[1:idx]))
-		if err != nil {
-			return nil, 0, false
+/*
+splitArgs splits an inline command line into arguments the same way redis-cli does.
+Double quoted arguments understand the usual backslash escapes including \xHH,
+single quoted arguments only understand \'. A closing quote must be followed by a space
+or the end of the line. It returns false if the quotes are unbalanced.
+*/
+func splitArgs(line string) ([]string, bool) {
+	var args []string
+	i := 0
+	for {
+		// skip blanks between arguments
+		for i < len(line) && isSpace(line[i]) {
+			i++
 		}
-		if length == -1 {
-			return &Resp{
-				Type: BulkString,
-				Str:  nil,
-			}, idx + 2, true
+		if i == len(line) {
+			return args, true
 		}
 
-		start := idx + 2
-		end := start + length
-
-		if len(buffer) < end+2 {
-			return nil, 0, false
+		var current strings.Builder
+		inDouble, inSingle, done := false, false, false
+		for !done {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, false
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDouble:
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current.WriteByte(byte(b))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						current.WriteByte('\n')
+					case 'r':
+						current.WriteByte('\r')
+					case 't':
+						current.WriteByte('\t')
+					case 'b':
+						current.WriteByte('\b')
+					case 'a':
+						current.WriteByte('\a')
+					default:
+						current.WriteByte(line[i])
+					}
+				} else if c == '"' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, false
+					}
+					done = true
+				} else {
+					current.WriteByte(c)
+				}
+			case inSingle:
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					current.WriteByte('\'')
+					i++
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, false
+					}
+					done = true
+				} else {
+					current.WriteByte(c)
+				}
+			default:
+				switch c {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					current.WriteByte(c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
 		}
+		args = append(args, current.String())
+	}
+}
 
-		if !bytes.Equal(buffer[end:end+2], []byte("\r\n")) {
-			return nil, 0, false
-		}
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == 0
+}
 
-		bytesConsumed := idx + 2 + length + 2
-		s := string(buffer[start:end])
-		return &Resp{
-			Type: BulkString,
-			Str:  &s,
-		}, bytesConsumed, true
-
-	case '*': // Array
-		idx := bytes.Index(buffer, []byte("\r\n"))
-		if idx == -1 {
-			return nil, 0, false
-		}
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
 
-		length, err := strconv.Atoi(string(buffer[1:idx]))
-		if err != nil {
-			return nil, 0, false
-		}
+// parseDouble parses a RESP3 double, which spells infinity and NaN as inf, -inf and nan.
+func parseDouble(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
 
-		if length == -1 {
-			return &Resp{
-				Type:  Array,
-				Array: nil,
-			}, idx + 2, true
-		}
+// FormatDouble formats a float the way RESP3 expects it on the wire.
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
 
-		items := make([]*Resp, 0, length)
-		bytesConsumed := idx + 2
-		for i := 0; i < length; i++ {
-			resp, consumed, ok := Parser(buffer[bytesConsumed:])
-			if !ok {
-				return nil, 0, false
-			}
-			items = append(items, resp)
-			bytesConsumed += consumed
+// isBigNumber reports whether s is an optionally signed string of decimal digits.
+func isBigNumber(s string) bool {
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
 		}
-		return &Resp{
-			Type:  Array,
-			Array: items,
-		}, bytesConsumed, true
 	}
-
-	return nil, 0, false
+	return true
 }
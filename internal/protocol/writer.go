@@ -0,0 +1,200 @@
+package resp
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxOutputBuffer is the largest output buffer a Writer keeps around after a flush.
+const maxOutputBuffer = 64 * 1024
+
+/*
+Writer buffers encoded RESP values in memory and writes them to the stream in one go on Flush,
+so the replies to a batch of pipelined commands cost a single write.
+*/
+type Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:   w,
+		buf: make([]byte, 0, 16*1024),
+	}
+}
+
+// WriteValue encodes r for the given protocol version into the output buffer.
+func (w *Writer) WriteValue(r *Resp, proto int) {
+	w.buf = AppendValue(w.buf, r, proto)
+}
+
+// Buffered returns the number of bytes waiting to be flushed.
+func (w *Writer) Buffered() int {
+	return len(w.buf)
+}
+
+// Flush writes the output buffer to the underlying stream.
+func (w *Writer) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.buf)
+	// drop a buffer that grew for a large reply so the memory is released
+	if cap(w.buf) > maxOutputBuffer {
+		w.buf = make([]byte, 0, 16*1024)
+	} else {
+		w.buf = w.buf[:0]
+	}
+	return err
+}
+
+/*
+AppendValue encodes a RESP object and appends it to dst, returning the extended buffer.
+proto is the protocol version negotiated by the client. RESP3 types are written as they are for
+proto 3 and downgraded to their closest RESP2 equivalent otherwise, e.g. a Map becomes a flat Array
+and a Null becomes a null bulk string.
+If an unknown RESP type is encountered, it appends an error message in RESP format.
+*/
+func AppendValue(dst []byte, r *Resp, proto int) []byte {
+	if proto >= 3 && len(r.Attrs) > 0 {
+		dst = appendAggregate(dst, '|', r.Attrs, 2, proto)
+	}
+
+	switch r.Type {
+
+	case SimpleString:
+		return appendLine(dst, '+', *r.Str)
+
+	case Error:
+		return appendLine(dst, '-', *r.Str)
+
+	case Integer:
+		dst = append(dst, ':')
+		dst = strconv.AppendInt(dst, r.Int, 10)
+		return append(dst, '\r', '\n')
+
+	case BulkString:
+		if r.Str == nil {
+			return appendNull(dst, '$', proto)
+		}
+		return appendBlob(dst, '$', *r.Str)
+
+	case Array:
+		if r.Array == nil {
+			return appendNull(dst, '*', proto)
+		}
+		return appendAggregate(dst, '*', r.Array, 1, proto)
+
+	case Null:
+		return appendNull(dst, '$', proto)
+
+	case Double:
+		d := FormatDouble(r.Double)
+		if proto >= 3 {
+			return appendLine(dst, ',', d)
+		}
+		return appendBlob(dst, '$', d)
+
+	case Boolean:
+		if proto >= 3 {
+			if r.Bool {
+				return append(dst, "#t\r\n"...)
+			}
+			return append(dst, "#f\r\n"...)
+		}
+		if r.Bool {
+			return append(dst, ":1\r\n"...)
+		}
+		return append(dst, ":0\r\n"...)
+
+	case BigNumber:
+		if proto >= 3 {
+			return appendLine(dst, '(', *r.Str)
+		}
+		return appendBlob(dst, '$', *r.Str)
+
+	case VerbatimString:
+		if proto >= 3 {
+			format := r.Format
+			if format == "" {
+				format = "txt"
+			}
+			return appendBlob(dst, '=', format+":"+*r.Str)
+		}
+		return appendBlob(dst, '$', *r.Str)
+
+	case BlobError:
+		if proto >= 3 {
+			return appendBlob(dst, '!', *r.Str)
+		}
+		// RESP2 errors cannot span lines
+		msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(*r.Str)
+		return appendLine(dst, '-', msg)
+
+	case Map:
+		if proto >= 3 {
+			return appendAggregate(dst, '%', r.Array, 2, proto)
+		}
+		return appendAggregate(dst, '*', r.Array, 1, proto)
+
+	case Set:
+		if proto >= 3 {
+			return appendAggregate(dst, '~', r.Array, 1, proto)
+		}
+		return appendAggregate(dst, '*', r.Array, 1, proto)
+
+	case Push:
+		if proto >= 3 {
+			return appendAggregate(dst, '>', r.Array, 1, proto)
+		}
+		return appendAggregate(dst, '*', r.Array, 1, proto)
+
+	case Attribute:
+		// RESP2 has no way to express attributes, so they are dropped
+		if proto >= 3 {
+			return appendAggregate(dst, '|', r.Array, 2, proto)
+		}
+		return dst
+	}
+	return append(dst, "-ERR unknown RESP type\r\n"...)
+}
+
+// appendLine appends a single line type such as a simple string or an error.
+func appendLine(dst []byte, prefix byte, s string) []byte {
+	dst = append(dst, prefix)
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+// appendBlob appends a length prefixed type such as a bulk string.
+func appendBlob(dst []byte, prefix byte, s string) []byte {
+	dst = append(dst, prefix)
+	dst = strconv.AppendInt(dst, int64(len(s)), 10)
+	dst = append(dst, '\r', '\n')
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+// appendNull appends the RESP3 null, or the RESP2 null bulk string or null array picked by prefix.
+func appendNull(dst []byte, prefix byte, proto int) []byte {
+	if proto >= 3 {
+		return append(dst, "_\r\n"...)
+	}
+	return append(dst, prefix, '-', '1', '\r', '\n')
+}
+
+/*
+appendAggregate appends the header of an aggregate type followed by its elements.
+per is the number of elements that make up one entry, 2 for maps and attributes.
+*/
+func appendAggregate(dst []byte, prefix byte, items []*Resp, per int, proto int) []byte {
+	dst = append(dst, prefix)
+	dst = strconv.AppendInt(dst, int64(len(items)/per), 10)
+	dst = append(dst, '\r', '\n')
+	for _, el := range items {
+		dst = AppendValue(dst, el, proto)
+	}
+	return dst
+}
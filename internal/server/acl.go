@@ -0,0 +1,276 @@
+package server
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blvckbill/redis-from-scratch/internal/acl"
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+// newACL returns an ACL that knows every command in the command table and its categories.
+func newACL() *acl.ACL {
+	cmds := make([]acl.Command, 0, len(commandTable))
+	for _, cmd := range sortedCommands() {
+		cmds = append(cmds, acl.Command{
+			Name:        cmd.name,
+			Categories:  cmd.aclCategories(),
+			Container:   cmd.container,
+			Subcommands: cmd.subcommandCategories(),
+		})
+	}
+	return acl.New(cmds)
+}
+
+/*
+checkACL checks that the client's user may run the command on its key and channel
+arguments. It returns the NOPERM error to reply with, after logging it, or nil.
+context is toplevel, or multi for commands run by EXEC, as reported by ACL LOG.
+*/
+func (s *Server) checkACL(c *client, cmd *command, argv []string, context string) *resp.Resp {
+	sub := ""
+	if cmd.container && len(argv) > 1 {
+		sub = strings.ToLower(argv[1])
+	}
+	user := c.username()
+	reason, object, ok := s.acl.Check(user, cmd.name, sub, cmd.keys(argv), cmd.channels(argv))
+	if ok {
+		return nil
+	}
+	s.acl.Log().Add(reason, context, object, user, s.clientInfo(c))
+
+	msg := "NOPERM User " + user + " has no permissions to run the '" + object + "' command"
+	switch reason {
+	case acl.ReasonKey:
+		msg = "NOPERM No permissions to access a key"
+	case acl.ReasonChannel:
+		msg = "NOPERM No permissions to access a channel"
+	}
+	return &resp.Resp{
+		Type: resp.Error,
+		Str:  strPtr(msg),
+	}
+}
+
+/*
+disconnectUsers closes the connection of every client authenticated as a user that no
+longer exists. The calling client, self, is closed only after it has got its reply.
+*/
+func (s *Server) disconnectUsers(self *client) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	for _, c := range s.clients {
+		if s.acl.Exists(c.username()) {
+			continue
+		}
+		s.killClient(self, c)
+	}
+}
+
+/*
+handleACL takes the arguments for the ACL command and returns a RESP response.
+ACL SETUSER username [rule ...]
+ACL GETUSER username
+ACL DELUSER username [username ...]
+ACL LIST | USERS | WHOAMI
+ACL CAT [category]
+ACL LOG [count | RESET]
+ACL LOAD | SAVE
+*/
+func (s *Server) handleACL(c *client, args []string) *resp.Resp {
+	sub := strings.ToUpper(args[0])
+	switch {
+	case sub == "SETUSER" && len(args) >= 2:
+		if err := s.acl.SetUser(args[1], args[2:]); err != nil {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR " + err.Error()),
+			}
+		}
+		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("OK"),
+		}
+
+	case sub == "GETUSER" && len(args) == 2:
+		info, ok := s.acl.GetUser(args[1])
+		if !ok {
+			return &resp.Resp{Type: resp.Null}
+		}
+		return &resp.Resp{
+			Type: resp.Map,
+			Array: []*resp.Resp{
+				{Type: resp.BulkString, Str: strPtr("flags")},
+				bulkArray(info.Flags),
+				{Type: resp.BulkString, Str: strPtr("passwords")},
+				bulkArray(info.Passwords),
+				{Type: resp.BulkString, Str: strPtr("commands")},
+				{Type: resp.BulkString, Str: strPtr(info.Commands)},
+				{Type: resp.BulkString, Str: strPtr("keys")},
+				{Type: resp.BulkString, Str: strPtr(info.Keys)},
+				{Type: resp.BulkString, Str: strPtr("channels")},
+				{Type: resp.BulkString, Str: strPtr(info.Channels)},
+				{Type: resp.BulkString, Str: strPtr("selectors")},
+				{Type: resp.Array, Array: []*resp.Resp{}},
+			},
+		}
+
+	case sub == "DELUSER" && len(args) >= 2:
+		n, err := s.acl.DelUser(args[1:]...)
+		if err != nil {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR " + err.Error()),
+			}
+		}
+		s.disconnectUsers(c)
+		return &resp.Resp{
+			Type: resp.Integer,
+			Int:  int64(n),
+		}
+
+	case sub == "LIST" && len(args) == 1:
+		return bulkArray(s.acl.List())
+
+	case sub == "USERS" && len(args) == 1:
+		return bulkArray(s.acl.Users())
+
+	case sub == "WHOAMI" && len(args) == 1:
+		user := acl.DefaultUser
+		if c != nil {
+			user = c.username()
+		}
+		return &resp.Resp{
+			Type: resp.BulkString,
+			Str:  strPtr(user),
+		}
+
+	case sub == "CAT" && len(args) <= 2:
+		if len(args) == 1 {
+			return bulkArray(acl.Categories)
+		}
+		cmds, ok := s.acl.CategoryCommands(args[1])
+		if !ok {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR Unknown category '" + args[1] + "'"),
+			}
+		}
+		return bulkArray(cmds)
+
+	case sub == "LOG" && len(args) <= 2:
+		count := 10
+		if len(args) == 2 {
+			if strings.ToUpper(args[1]) == "RESET" {
+				s.acl.Log().Reset()
+				return &resp.Resp{
+					Type: resp.SimpleString,
+					Str:  strPtr("OK"),
+				}
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR value is out of range, must be positive"),
+				}
+			}
+			count = n
+		}
+		entries := s.acl.Log().Entries(count)
+		out := make([]*resp.Resp, len(entries))
+		for i, e := range entries {
+			out[i] = logEntryReply(e)
+		}
+		return &resp.Resp{
+			Type:  resp.Array,
+			Array: out,
+		}
+
+	case (sub == "LOAD" || sub == "SAVE") && len(args) == 1:
+		path := s.cfg.String("aclfile")
+		if path == "" {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration."),
+			}
+		}
+		var err error
+		if sub == "LOAD" {
+			err = s.acl.LoadFile(path)
+			if err == nil {
+				s.disconnectUsers(c)
+			}
+		} else {
+			err = s.acl.SaveFile(path)
+		}
+		if err != nil {
+			msg := err.Error()
+			if sub == "SAVE" {
+				log.Printf("Error saving ACLs: %v", err)
+				msg = "There was an error trying to save the ACLs. Please check the server logs for more information"
+			}
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR " + msg),
+			}
+		}
+		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("OK"),
+		}
+	}
+
+	return &resp.Resp{
+		Type: resp.Error,
+		Str:  strPtr("ERR unknown subcommand or wrong number of arguments for '" + args[0] + "'"),
+	}
+}
+
+// logEntryReply returns the map ACL LOG gives for a single entry.
+func logEntryReply(e acl.LogEntry) *resp.Resp {
+	age := time.Since(e.Created).Seconds()
+	return &resp.Resp{
+		Type: resp.Map,
+		Array: []*resp.Resp{
+			{Type: resp.BulkString, Str: strPtr("count")},
+			{Type: resp.Integer, Int: e.Count},
+			{Type: resp.BulkString, Str: strPtr("reason")},
+			{Type: resp.BulkString, Str: strPtr(e.Reason)},
+			{Type: resp.BulkString, Str: strPtr("context")},
+			{Type: resp.BulkString, Str: strPtr(e.Context)},
+			{Type: resp.BulkString, Str: strPtr("object")},
+			{Type: resp.BulkString, Str: strPtr(e.Object)},
+			{Type: resp.BulkString, Str: strPtr("username")},
+			{Type: resp.BulkString, Str: strPtr(e.Username)},
+			{Type: resp.BulkString, Str: strPtr("age-seconds")},
+			{Type: resp.Double, Double: age},
+			{Type: resp.BulkString, Str: strPtr("client-info")},
+			{Type: resp.BulkString, Str: strPtr(e.ClientInfo)},
+			{Type: resp.BulkString, Str: strPtr("entry-id")},
+			{Type: resp.Integer, Int: e.ID},
+			{Type: resp.BulkString, Str: strPtr("timestamp-created")},
+			{Type: resp.Integer, Int: e.Created.UnixMilli()},
+			{Type: resp.BulkString, Str: strPtr("timestamp-last-updated")},
+			{Type: resp.Integer, Int: e.Updated.UnixMilli()},
+		},
+	}
+}
+
+// bulkArray returns an array of bulk strings.
+func bulkArray(values []string) *resp.Resp {
+	out := make([]*resp.Resp, len(values))
+	for i, v := range values {
+		out[i] = &resp.Resp{
+			Type: resp.BulkString,
+			Str:  strPtr(v),
+		}
+	}
+	return &resp.Resp{
+		Type:  resp.Array,
+		Array: out,
+	}
+}
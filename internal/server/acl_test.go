@@ -0,0 +1,54 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+func TestDangerousSubcommands(t *testing.T) {
+	ts := startServer(t)
+	admin := ts.dial(t)
+	expect(t, admin.do("ACL", "SETUSER", "alice", "on", "nopass", "~*", "+@all", "-@dangerous"), "OK")
+
+	c := ts.dial(t)
+	expect(t, c.do("AUTH", "alice", "x"), "OK")
+	expect(t, c.do("CONFIG", "GET", "port"), "-NOPERM User alice has no permissions to run the 'config|get' command")
+	for _, argv := range [][]string{
+		{"ACL", "SETUSER", "alice", "+@all"},
+		{"ACL", "DELUSER", "default"},
+		{"ACL", "GETUSER", "default"},
+		{"ACL", "LOG", "RESET"},
+		{"CLIENT", "KILL", "ID", "1"},
+		{"CLIENT", "LIST"},
+	} {
+		reply := c.do(argv...)
+		if got := show(reply); !strings.HasPrefix(got, "-NOPERM") {
+			t.Errorf("%v: got %s, want NOPERM", argv, got)
+		}
+	}
+
+	// the harmless subcommands stay allowed
+	expect(t, c.do("ACL", "WHOAMI"), "alice")
+	if reply := c.do("ACL", "CAT"); reply.Type == resp.Error {
+		t.Fatalf("ACL CAT: got %s", show(reply))
+	}
+	expect(t, c.do("CLIENT", "SETNAME", "x"), "OK")
+	expect(t, c.do("CONFIG", "GET", "port"), "-NOPERM User alice has no permissions to run the 'config|get' command")
+}
+
+func TestAdminCategoryAllowsSubcommands(t *testing.T) {
+	ts := startServer(t)
+	admin := ts.dial(t)
+	expect(t, admin.do("ACL", "SETUSER", "bob", "on", "nopass", "-@all", "+@admin"), "OK")
+	admins := show(admin.do("ACL", "CAT", "admin"))
+	if !strings.Contains(admins, "acl|setuser") || !strings.Contains(admins, "client|kill") || strings.Contains(admins, "acl|whoami") {
+		t.Fatalf("ACL CAT admin = %s", admins)
+	}
+
+	c := ts.dial(t)
+	expect(t, c.do("AUTH", "bob", "x"), "OK")
+	expect(t, c.do("ACL", "SETUSER", "carol"), "OK")
+	expect(t, c.do("ACL", "WHOAMI"), "-NOPERM User bob has no permissions to run the 'acl|whoami' command")
+}
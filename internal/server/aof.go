@@ -1,60 +1,126 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
 )
 
+// fsync policies, named after the appendfsync config values
+const (
+	FsyncAlways   = "always"   // fsync after every write
+	FsyncEverySec = "everysec" // fsync once a second in the background
+	FsyncNo       = "no"       // leave flushing to the operating system
+)
+
 type AOFLogger struct {
-	file *os.File
-	mu   sync.RWMutex
+	file  *os.File
+	mu    sync.RWMutex
+	fsync string // one of the Fsync policies, guarded by mu
+
+	done      chan struct{} // closed by Close to stop the background fsync
+	closeOnce sync.Once
 }
 
-func NewAOFLogger(path string) (*AOFLogger, error) {
+func NewAOFLogger(path string, fsync string) (*AOFLogger, error) {
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
 	if err != nil {
 		return nil, err
 	}
 
 	a := &AOFLogger{
-		file: file,
+		file:  file,
+		fsync: fsync,
+		done:  make(chan struct{}),
 	}
 	go a.BackgroundFsync()
 	return a, nil
 }
 
-func (a *AOFLogger) Append(cmd []byte) error {
+// SetFsyncPolicy switches the fsync policy, used when appendfsync is changed at runtime.
+func (a *AOFLogger) SetFsyncPolicy(fsync string) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	_, err := a.file.Write(cmd)
+	a.fsync = fsync
+}
+
+func (a *AOFLogger) Append(cmd []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	return err
+	if _, err := a.file.Write(cmd); err != nil {
+		return err
+	}
+	if a.fsync == FsyncAlways {
+		return a.file.Sync()
+	}
+	return nil
 }
 
 func (a *AOFLogger) BackgroundFsync() error {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-a.done:
+			return nil
+		case <-ticker.C:
+		}
+
 		a.mu.Lock()
-		err := a.file.Sync()
+		var err error
+		if a.fsync == FsyncEverySec {
+			err = a.file.Sync()
+		}
 		a.mu.Unlock()
 
 		if err != nil {
 			log.Printf("AOF fsync error: %v", err)
 		}
 	}
+}
 
-	return nil
+// Sync flushes everything appended so far to disk.
+func (a *AOFLogger) Sync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.file.Sync()
 }
 
-// Replay reads the AOF file and replays the commands into the store
+// Close stops the background fsync, flushes the file to disk and closes it.
+func (a *AOFLogger) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		close(a.done)
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		err = a.file.Sync()
+		if cerr := a.file.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+/*
+Replay reads the AOF file and replays the commands into the store.
+A malformed command stops the replay with an error that carries its byte offset in the file.
+A command cut short at the end of the file, e.g. after a crash mid write, is logged and cut off.
+The commands of a MULTI ... EXEC block only run once its EXEC has been read, a block left
+open at the end of the file is an incomplete transaction and is cut off as a whole.
+*/
 func (a *AOFLogger) Replay(s *Server) error {
 	file, err := os.Open(a.file.Name())
 	if err != nil {
@@ -62,38 +128,52 @@ func (a *AOFLogger) Replay(s *Server) error {
 	}
 	defer file.Close()
 
-	readbuf := make([]byte, 4096)
-	var buffer []byte
-
-	// Read the file in chunks and process the commands
+	rd := resp.NewReader(file)
+	var tx [][]string // commands of the open MULTI block
+	txStart := -1     // offset of the open MULTI, -1 outside a block
 	for {
-		bytesRead, err := file.Read(readbuf)
+		// offset of the command about to be read, used to cut off a truncated tail
+		offset := rd.Offset()
+		args, err := rd.ReadCommand()
 		if err != nil {
+			if (err == io.EOF || err == io.ErrUnexpectedEOF) && txStart >= 0 {
+				log.Printf("AOF ends inside a transaction at offset %d, discarding the last %d bytes", txStart, rd.Offset()-txStart)
+				return a.file.Truncate(int64(txStart))
+			}
 			if err == io.EOF {
-				break
-			} else {
-				log.Fatalf("Error reading file: %v", err)
+				return nil
 			}
-		}
-		buffer = append(buffer, readbuf[:bytesRead]...)
-
-		// Process the buffer for complete RESP commands
-		for {
-			// Try to parse the buffer as RESP, if successful, execute the command and write the response back to the client
-			parsedResp, consumed, ok := resp.Parser(buffer)
-			if !ok {
-				break
+			if err == io.ErrUnexpectedEOF {
+				// cut the partial command off so new appends don't land after it
+				log.Printf("AOF is truncated at offset %d, discarding the last %d bytes", offset, rd.Offset()-offset)
+				return a.file.Truncate(int64(offset))
+			}
+			var perr *resp.ProtocolError
+			if errors.As(err, &perr) {
+				return fmt.Errorf("bad file format at offset %d: %w", perr.Offset, err)
 			}
-			// Remove the parsed command from the buffer
-			buffer = buffer[consumed:]
+			return err
+		}
 
-			//convert to argv
-			parsed, ok := ParsedRespToStrings(parsedResp)
-			if !ok {
-				log.Printf("Error parsing RESP to strings")
+		argv := argsToStrings(args)
+		if len(argv) == 0 {
+			continue
+		}
+		switch strings.ToLower(argv[0]) {
+		case "multi":
+			txStart = offset
+			continue
+		case "exec":
+			for _, cmd := range tx {
+				s.commandExecution(nil, cmd)
 			}
-			s.commandExecution(nil, parsed)
+			tx, txStart = nil, -1
+			continue
 		}
+		if txStart >= 0 {
+			tx = append(tx, argv)
+			continue
+		}
+		s.commandExecution(nil, argv)
 	}
-	return nil
 }
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/blvckbill/redis-from-scratch/internal/acl"
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+// authRequired reports whether c has to authenticate before running commands.
+// Clients start as the default user, they need no AUTH while it is enabled and nopass.
+func (s *Server) authRequired(c *client) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	authenticated, user := c.authenticated, c.user
+	c.mu.Unlock()
+	return !authenticated && !s.acl.NoPass(user)
+}
+
+// applyRequirepass makes requirepass the only password of the default user, an empty requirepass makes it nopass.
+func (s *Server) applyRequirepass() {
+	rules := []string{"nopass"}
+	if pass := s.cfg.String("requirepass"); pass != "" {
+		rules = []string{"resetpass", ">" + pass}
+	}
+	s.acl.SetUser(acl.DefaultUser, rules)
+}
+
+// logAuthFailure records a failed AUTH or HELLO AUTH in the ACL log.
+func (s *Server) logAuthFailure(c *client, username string) {
+	info := ""
+	if c != nil {
+		info = s.clientInfo(c)
+	}
+	s.acl.Log().Add(acl.ReasonAuth, "toplevel", "AUTH", username, info)
+}
+
+/*
+handleAuth takes the arguments for the AUTH command and returns a RESP response.
+AUTH [username] password
+Without a username the password is checked against the default user, whose password
+is set with requirepass or ACL SETUSER.
+*/
+func (s *Server) handleAuth(c *client, args []string) *resp.Resp {
+	if len(args) > 2 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+
+	username, password := acl.DefaultUser, args[0]
+	if len(args) == 2 {
+		username, password = args[0], args[1]
+	} else if s.acl.NoPass(acl.DefaultUser) {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"),
+		}
+	}
+
+	if !s.acl.Authenticate(username, password) {
+		s.logAuthFailure(c, username)
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("WRONGPASS invalid username-password pair or user is disabled."),
+		}
+	}
+	if c != nil {
+		c.login(username)
+	}
+	return &resp.Resp{
+		Type: resp.SimpleString,
+		Str:  strPtr("OK"),
+	}
+}
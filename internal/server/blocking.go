@@ -0,0 +1,383 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+/*
+serveFunc tries to serve a blocking command from the list at key. It returns the reply and
+true if the list had data, after propagating what it did on behalf of via, the client the
+command runs for or nil when it is served by another client's push.
+It is always called with blockMu held.
+*/
+type serveFunc func(key string, via *client) (*resp.Resp, bool)
+
+// blockedClient is a client parked by a blocking command until one of its keys gets data.
+type blockedClient struct {
+	c     *client
+	keys  []string
+	serve serveFunc
+	reply chan *resp.Resp // buffered, receives the reply once a push has served the client
+}
+
+/*
+block runs a blocking command for c on keys. serve is tried on each key in order and if none
+of them has data the client is parked, in FIFO order behind clients already blocked on the
+same keys, until a push serves it, the timeout passes or the client disconnects; a zero
+timeout waits forever. A key other clients are still blocked on is theirs first, the client
+only queues behind them. Outside of a real connection, inside EXEC or during an AOF replay,
+it never waits and replies with timedOut straight away like Redis does.
+*/
+func (s *Server) block(c *client, keys []string, timeout time.Duration, serve serveFunc, timedOut *resp.Resp) *resp.Resp {
+	nonBlocking := c == nil || c.execing
+	// inside EXEC the transaction already holds txMu for writing
+	if !nonBlocking {
+		s.txMu.RLock()
+	}
+	s.blockMu.Lock()
+	for _, key := range keys {
+		s.serveBlockedFirst(c, key)
+		if !nonBlocking && len(s.blocked[key]) > 0 {
+			continue
+		}
+		if reply, ok := serve(key, c); ok {
+			// serving may have pushed to a key, BLMOVE does
+			if !nonBlocking {
+				s.serveReadyLocked()
+			}
+			s.blockMu.Unlock()
+			if !nonBlocking {
+				s.txMu.RUnlock()
+			}
+			return reply
+		}
+	}
+	if nonBlocking {
+		s.blockMu.Unlock()
+		return timedOut
+	}
+
+	bc := &blockedClient{
+		c:     c,
+		keys:  keys,
+		serve: serve,
+		reply: make(chan *resp.Resp, 1),
+	}
+	for _, key := range keys {
+		s.blocked[key] = append(s.blocked[key], bc)
+	}
+	s.blockMu.Unlock()
+	s.txMu.RUnlock()
+
+	// replies to the commands pipelined before this one must not wait for it
+	c.flush()
+
+	// a parked client is not running a command as far as SHUTDOWN is concerned
+	c.blocked.Store(true)
+	s.inflight.Add(-1)
+	defer func() {
+		s.inflight.Add(1)
+		c.blocked.Store(false)
+	}()
+
+	gone, stopWatching := c.watchDisconnect()
+	defer stopWatching()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case reply := <-bc.reply:
+		return reply
+	case <-expired:
+	case <-gone:
+	}
+
+	s.blockMu.Lock()
+	defer s.blockMu.Unlock()
+
+	// a push may have served the client while it was timing out
+	select {
+	case reply := <-bc.reply:
+		return reply
+	default:
+	}
+	s.unblock(bc)
+	return timedOut
+}
+
+// unblock removes bc from the queue of every key it is blocked on, it must be called with blockMu held.
+func (s *Server) unblock(bc *blockedClient) {
+	for _, key := range bc.keys {
+		queue := s.blocked[key]
+		for i := 0; i < len(queue); i++ {
+			if queue[i] == bc {
+				queue = append(queue[:i], queue[i+1:]...)
+				i--
+			}
+		}
+		if len(queue) == 0 {
+			delete(s.blocked, key)
+		} else {
+			s.blocked[key] = queue
+		}
+	}
+}
+
+/*
+signalReadyLocked records that key got data, clients blocked on it are served once the push
+has been propagated. It must be called with blockMu held.
+*/
+func (s *Server) signalReadyLocked(key string) {
+	if len(s.blocked[key]) == 0 || s.readySet[key] {
+		return
+	}
+	s.readySet[key] = true
+	s.readyKeys = append(s.readyKeys, key)
+}
+
+/*
+handleReadyKeys serves the clients blocked on keys that got data, in the order they blocked,
+for as long as the lists have elements. Serving one client may push to another key, e.g.
+BLMOVE, which is then served in turn.
+*/
+func (s *Server) handleReadyKeys() {
+	s.blockMu.Lock()
+	pending := len(s.readyKeys) > 0
+	s.blockMu.Unlock()
+	if !pending {
+		return
+	}
+
+	s.txMu.RLock()
+	defer s.txMu.RUnlock()
+	s.blockMu.Lock()
+	defer s.blockMu.Unlock()
+
+	s.serveReadyLocked()
+}
+
+// serveReadyLocked serves the clients blocked on the keys signalled ready, it must be called with txMu held for reading and blockMu held.
+func (s *Server) serveReadyLocked() {
+	for len(s.readyKeys) > 0 {
+		key := s.readyKeys[0]
+		s.readyKeys = s.readyKeys[1:]
+		delete(s.readySet, key)
+		s.serveBlocked(key)
+	}
+}
+
+// serveBlocked serves the clients blocked on key in the order they blocked, for as long as the list has elements.
+func (s *Server) serveBlocked(key string) {
+	for len(s.blocked[key]) > 0 {
+		bc := s.blocked[key][0]
+		reply, ok := bc.serve(key, nil)
+		if !ok {
+			break
+		}
+		s.unblock(bc)
+		bc.reply <- reply
+	}
+}
+
+/*
+serveBlockedFirst serves the clients blocked on key before c takes elements from it, so a
+pop cannot get ahead of the clients already waiting, which only happens if c runs between
+a push to key and handleReadyKeys. It must be called with blockMu held. Inside EXEC the
+transaction's own commands go first, as in Redis.
+*/
+func (s *Server) serveBlockedFirst(c *client, key string) {
+	if c == nil || c.execing || len(s.blocked[key]) == 0 {
+		return
+	}
+	s.serveBlocked(key)
+}
+
+/*
+watchDisconnect watches the connection of a client parked by a blocking command, which is
+not reading its commands, and closes gone if the client disconnects. Commands the client
+sends in the meantime are buffered for later, the watch goes on until stop is called, which
+must happen before the connection is read again.
+A client that overflows its query buffer while parked is dropped as if it had disconnected.
+*/
+func (c *client) watchDisconnect() (gone <-chan struct{}, stop func()) {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			err := c.rd.Fill()
+			if err == nil {
+				continue
+			}
+			if !isTimeout(err) {
+				c.conn.Close()
+				close(closed)
+			}
+			return
+		}
+	}()
+	return closed, func() {
+		// wake the watcher up with a deadline in the past, then clear it
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+		c.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+func isTimeout(err error) bool {
+	nerr, ok := err.(interface{ Timeout() bool })
+	return ok && nerr.Timeout()
+}
+
+// parseTimeout parses the timeout of a blocking command, in seconds with an optional fraction.
+func parseTimeout(arg string) (time.Duration, *resp.Resp) {
+	secs, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) || secs*float64(time.Second) > math.MaxInt64 {
+		return 0, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR timeout is not a float or out of range"),
+		}
+	}
+	if secs < 0 {
+		return 0, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR timeout is negative"),
+		}
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// popName returns the non-blocking pop a served blocking pop is propagated as.
+func popName(left bool) string {
+	if left {
+		return "LPOP"
+	}
+	return "RPOP"
+}
+
+// directionName returns the LEFT or RIGHT argument of a list move or pop.
+func directionName(left bool) string {
+	if left {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+/*
+handleBLPop takes the arguments for the BLPOP command and returns a RESP response.
+BLPOP key [key ...] timeout
+*/
+func (s *Server) handleBLPop(c *client, args []string) *resp.Resp {
+	return s.blockingPop(c, args, true)
+}
+
+/*
+handleBRPop takes the arguments for the BRPOP command and returns a RESP response.
+BRPOP key [key ...] timeout
+*/
+func (s *Server) handleBRPop(c *client, args []string) *resp.Resp {
+	return s.blockingPop(c, args, false)
+}
+
+// blockingPop implements BLPOP and BRPOP, replying with the key and the element popped from it.
+func (s *Server) blockingPop(c *client, args []string, left bool) *resp.Resp {
+	timeout, errResp := parseTimeout(args[len(args)-1])
+	if errResp != nil {
+		return errResp
+	}
+	keys := args[:len(args)-1]
+
+	serve := func(key string, via *client) (*resp.Resp, bool) {
+		items, err := s.store.PopN(key, 1, left)
+		if err != nil {
+			return errorReply(err), true
+		}
+		if len(items) == 0 {
+			return nil, false
+		}
+		s.propagate(via, []string{popName(left), key})
+		return &resp.Resp{
+			Type: resp.Array,
+			Array: []*resp.Resp{
+				{Type: resp.BulkString, Str: strPtr(key)},
+				{Type: resp.BulkString, Str: &items[0]},
+			},
+		}, true
+	}
+	return s.block(c, keys, timeout, serve, &resp.Resp{Type: resp.Array, Array: nil})
+}
+
+/*
+handleBLMove takes the arguments for the BLMOVE command and returns a RESP response.
+BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+*/
+func (s *Server) handleBLMove(c *client, args []string) *resp.Resp {
+	src, dst := args[0], args[1]
+	fromLeft, ok1 := parseDirection(args[2])
+	toLeft, ok2 := parseDirection(args[3])
+	if !ok1 || !ok2 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+	timeout, errResp := parseTimeout(args[4])
+	if errResp != nil {
+		return errResp
+	}
+
+	serve := func(key string, via *client) (*resp.Resp, bool) {
+		item, ok, err := s.store.LMove(src, dst, fromLeft, toLeft)
+		if err != nil {
+			return errorReply(err), true
+		}
+		if !ok {
+			return nil, false
+		}
+		s.propagate(via, []string{"LMOVE", src, dst, args[2], args[3]})
+		s.signalReadyLocked(dst)
+		return &resp.Resp{
+			Type: resp.BulkString,
+			Str:  &item,
+		}, true
+	}
+	return s.block(c, []string{src}, timeout, serve, &resp.Resp{Type: resp.Null})
+}
+
+/*
+handleBLMPop takes the arguments for the BLMPOP command and returns a RESP response.
+BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+It pops up to count elements from the first non-empty list and replies with its key and the elements.
+*/
+func (s *Server) handleBLMPop(c *client, args []string) *resp.Resp {
+	timeout, errResp := parseTimeout(args[0])
+	if errResp != nil {
+		return errResp
+	}
+	keys, left, count, errResp := parseMPop(args[1:])
+	if errResp != nil {
+		return errResp
+	}
+
+	serve := func(key string, via *client) (*resp.Resp, bool) {
+		reply, ok := s.mpop(key, left, count)
+		if ok && reply.Type != resp.Error {
+			s.propagate(via, []string{"LMPOP", "1", key, directionName(left), "COUNT", strconv.Itoa(count)})
+		}
+		return reply, ok
+	}
+	return s.block(c, keys, timeout, serve, &resp.Resp{Type: resp.Array, Array: nil})
+}
+
+func blmpopKeys(argv []string) []string {
+	return numkeysArgs(argv, 2)
+}
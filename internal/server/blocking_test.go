@@ -0,0 +1,149 @@
+package server
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlockFlushesPipelinedReplies(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+	batch := append(encodeCommand([]string{"SET", "x", "1"}), encodeCommand([]string{"BLPOP", "q", "10"})...)
+	if _, err := c.conn.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// the SET reply has to arrive while BLPOP is still waiting
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	reply, err := c.rd.ReadValue()
+	if err != nil {
+		t.Fatalf("no reply to SET while BLPOP blocks: %v", err)
+	}
+	expect(t, reply, "OK")
+
+	expect(t, ts.dial(t).do("RPUSH", "q", "a"), "1")
+	expect(t, c.read(), "[q a]")
+}
+
+// waitBlocked waits until n clients are parked by blocking commands.
+func (ts *testServer) waitBlocked(t *testing.T, n int) {
+	t.Helper()
+	want := "blocked_clients:" + strconv.Itoa(n) + "\r\n"
+	c := ts.dial(t)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if strings.Contains(show(c.do("INFO", "clients")), want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("never got %d blocked clients", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBlockedClientsServedInOrder(t *testing.T) {
+	aof := filepath.Join(t.TempDir(), "appendonly.aof")
+	ts := startServer(t, "--appendonly", "yes", "--appendfilename", aof)
+	first, second := ts.dial(t), ts.dial(t)
+	first.send("BLPOP", "q", "0")
+	ts.waitBlocked(t, 1)
+	second.send("BRPOP", "other", "q", "0")
+	ts.waitBlocked(t, 2)
+
+	c := ts.dial(t)
+	expect(t, c.do("RPUSH", "q", "a", "b", "c"), "3")
+	expect(t, first.read(), "[q a]")
+	expect(t, second.read(), "[q c]")
+	expect(t, c.do("LRANGE", "q", "0", "-1"), "[b]")
+
+	// the pushes are logged before the pops they served, so a replay ends up in the same state
+	c.send("SHUTDOWN")
+	c.expectClosed()
+	ts.stopped(t)
+	ts2 := startServer(t, "--appendonly", "yes", "--appendfilename", aof)
+	expect(t, ts2.dial(t).do("LRANGE", "q", "0", "-1"), "[b]")
+}
+
+func TestNewcomerQueuesBehindWaiters(t *testing.T) {
+	ts := startServer(t)
+	waiter, newcomer := ts.dial(t), ts.dial(t)
+	waiter.send("BLPOP", "q", "0")
+	ts.waitBlocked(t, 1)
+
+	// data that has not been handed to the waiter yet, as between a push and handleReadyKeys
+	if _, err := ts.store.RPush("q", "a"); err != nil {
+		t.Fatal(err)
+	}
+	newcomer.send("BLPOP", "q", "0")
+	expect(t, waiter.read(), "[q a]")
+	ts.waitBlocked(t, 1)
+
+	expect(t, ts.dial(t).do("RPUSH", "q", "b"), "1")
+	expect(t, newcomer.read(), "[q b]")
+}
+
+func TestPopDoesNotStealFromWaiters(t *testing.T) {
+	ts := startServer(t)
+	waiter := ts.dial(t)
+	waiter.send("BLPOP", "q", "0")
+	ts.waitBlocked(t, 1)
+
+	if _, err := ts.store.RPush("q", "a"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, ts.dial(t).do("LPOP", "q"), "nil")
+	expect(t, waiter.read(), "[q a]")
+}
+
+func TestPushInsideExecServesWaitersAfterwards(t *testing.T) {
+	ts := startServer(t)
+	waiter := ts.dial(t)
+	waiter.send("BLPOP", "q", "0")
+	ts.waitBlocked(t, 1)
+
+	c := ts.dial(t)
+	expect(t, c.do("MULTI"), "OK")
+	expect(t, c.do("RPUSH", "q", "a", "b"), "QUEUED")
+	expect(t, c.do("LPOP", "q"), "QUEUED")
+	// the transaction's own pop goes first
+	expect(t, c.do("EXEC"), "[2 a]")
+	expect(t, waiter.read(), "[q b]")
+}
+
+func TestBlockedClientDisconnectsWithPipelinedCommands(t *testing.T) {
+	ts := startServer(t)
+	for _, extra := range []int{1, 100000} {
+		c := ts.dial(t)
+		// commands sent behind BLPOP, more than fits in the read buffer in the second round
+		batch := encodeCommand([]string{"BLPOP", "q", "0"})
+		for range extra {
+			batch = append(batch, encodeCommand([]string{"PING"})...)
+		}
+		go c.conn.Write(batch)
+		ts.waitBlocked(t, 1)
+
+		c.conn.Close()
+		ts.waitBlocked(t, 0)
+		other := ts.dial(t)
+		expect(t, other.do("RPUSH", "q", "a"), "1")
+		expect(t, other.do("LRANGE", "q", "0", "-1"), "[a]")
+		expect(t, other.do("DEL", "q"), "1")
+	}
+}
+
+func TestBlockedClientRunsPipelinedCommandsOnceServed(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+	batch := append(encodeCommand([]string{"BLPOP", "q", "0"}), encodeCommand([]string{"PING"})...)
+	if _, err := c.conn.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	ts.waitBlocked(t, 1)
+
+	expect(t, ts.dial(t).do("RPUSH", "q", "a"), "1")
+	expect(t, c.read(), "[q a]")
+	expect(t, c.read(), "PONG")
+}
@@ -0,0 +1,558 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blvckbill/redis-from-scratch/internal/acl"
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+/*
+client holds the state the server keeps for a single connection.
+Everything CLIENT LIST reports is either fixed at connect time, atomic or guarded by mu,
+since other clients read it from their own goroutines.
+*/
+type client struct {
+	id      int64
+	conn    net.Conn
+	addr    string // remote address, path:0 for Unix sockets like Redis
+	laddr   string // local address
+	created time.Time
+
+	// mu guards the fields below, which other clients read for CLIENT LIST, CLIENT KILL and ACL DELUSER
+	mu            sync.Mutex
+	name          string
+	user          string
+	authenticated bool   // set by a successful AUTH, not needed while the user is nopass
+	lastCmd       string // name of the last command run, with its subcommand for containers
+	libName       string
+	libVer        string
+	multi         bool              // between MULTI and EXEC or DISCARD
+	multiDirty    bool              // a command failed to queue, EXEC will abort
+	queued        [][]string        // commands queued since MULTI
+	watched       map[string]uint64 // keys watched with WATCH and their versions at the time
+
+	lastInteraction atomic.Int64 // unix nanoseconds of the last command received
+	qbuf            atomic.Int64 // bytes of the query buffer not consumed yet
+	closeAfterReply atomic.Bool  // drop the connection once the current reply has been flushed
+	blocked         atomic.Bool  // parked by a blocking command
+
+	// only used by the client's own goroutine
+	rd         *resp.Reader
+	execing    bool   // running the commands queued for EXEC
+	execWrites []byte // writes of the running EXEC, appended to the AOF as one block
+
+	// wmu serialises writes, pub/sub messages are written from the publisher's goroutine
+	wmu      sync.Mutex
+	wr       *resp.Writer
+	protover int // 2 for RESP2, 3 for RESP3 after a HELLO 3, guarded by wmu
+}
+
+func (s *Server) newClient(conn net.Conn) *client {
+	c := &client{
+		id:       s.nextClientID.Add(1),
+		conn:     conn,
+		created:  time.Now(),
+		protover: 2,
+		user:     acl.DefaultUser,
+		wr:       resp.NewWriter(conn),
+	}
+	c.addr, c.laddr = conn.RemoteAddr().String(), conn.LocalAddr().String()
+	if conn.LocalAddr().Network() == "unix" {
+		c.addr = conn.LocalAddr().String() + ":0"
+		c.laddr = c.addr
+	}
+	c.lastInteraction.Store(c.created.UnixNano())
+	return c
+}
+
+var (
+	errClosing    = errors.New("server is shutting down")
+	errMaxClients = errors.New("max number of clients reached")
+)
+
+// addClient registers a new connection, it fails if the server is shutting down or already has maxclients clients.
+func (s *Server) addClient(conn net.Conn) (*client, error) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	if s.closing.Load() {
+		return nil, errClosing
+	}
+	if int64(len(s.clients)) >= s.cfg.Int("maxclients") {
+		return nil, errMaxClients
+	}
+	c := s.newClient(conn)
+	s.clients[c.id] = c
+	s.clientsWG.Add(1)
+	return c, nil
+}
+
+// rejectClient tells a connection over the maxclients limit why it is refused and closes it.
+func rejectClient(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("-ERR " + errMaxClients.Error() + "\r\n"))
+}
+
+// removeClient closes a client's connection, drops its subscriptions and watched keys and forgets about it.
+func (s *Server) removeClient(c *client) {
+	c.conn.Close()
+
+	s.pubsubMu.Lock()
+	for ch, subs := range s.channels {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(s.channels, ch)
+		}
+	}
+	s.pubsubMu.Unlock()
+	s.unwatchAll(c)
+
+	s.connMu.Lock()
+	delete(s.clients, c.id)
+	s.connMu.Unlock()
+
+	s.clientsWG.Done()
+}
+
+// username returns the ACL user the client is authenticated as.
+func (c *client) username() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.user
+}
+
+// login marks the client as authenticated as user.
+func (c *client) login(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.user = user
+	c.authenticated = true
+}
+
+// clientName returns the name set with CLIENT SETNAME or HELLO SETNAME.
+func (c *client) clientName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.name
+}
+
+func (c *client) setName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.name = name
+}
+
+// setProtover switches the protocol version replies are encoded with.
+func (c *client) setProtover(proto int) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.protover = proto
+}
+
+// touch records that a command named cmd was received, for the idle time and cmd field of CLIENT LIST.
+func (c *client) touch(cmd string) {
+	c.lastInteraction.Store(time.Now().UnixNano())
+
+	c.mu.Lock()
+	c.lastCmd = cmd
+	c.mu.Unlock()
+}
+
+// idle returns how long ago the client sent its last command.
+func (c *client) idle() time.Duration {
+	return time.Since(time.Unix(0, c.lastInteraction.Load()))
+}
+
+// subscriptions returns the number of channels c is subscribed to.
+func (s *Server) subscriptions(c *client) int {
+	s.pubsubMu.RLock()
+	defer s.pubsubMu.RUnlock()
+
+	count := 0
+	for _, subs := range s.channels {
+		if subs[c] {
+			count++
+		}
+	}
+	return count
+}
+
+// clientType returns the type CLIENT LIST TYPE and CLIENT KILL TYPE match against.
+func (s *Server) clientType(c *client) string {
+	if s.subscriptions(c) > 0 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+/*
+clientInfo describes a client the way CLIENT LIST and CLIENT INFO do, as space separated
+field=value pairs. The flags are P for a pub/sub subscriber, x inside MULTI, b for a client
+blocked by a blocking command and N for none of them.
+*/
+func (s *Server) clientInfo(c *client) string {
+	sub := s.subscriptions(c)
+
+	c.mu.Lock()
+	name, user, lastCmd, libName, libVer := c.name, c.user, c.lastCmd, c.libName, c.libVer
+	multi := -1
+	if c.multi {
+		multi = len(c.queued)
+	}
+	c.mu.Unlock()
+
+	flags := ""
+	if sub > 0 {
+		flags += "P"
+	}
+	if multi >= 0 {
+		flags += "x"
+	}
+	if c.blocked.Load() {
+		flags += "b"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	if lastCmd == "" {
+		lastCmd = "NULL"
+	}
+
+	c.wmu.Lock()
+	obl, proto := c.wr.Buffered(), c.protover
+	c.wmu.Unlock()
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=0 multi=%d qbuf=%d obl=%d cmd=%s user=%s resp=%d lib-name=%s lib-ver=%s",
+		c.id, c.addr, c.laddr, name, int64(time.Since(c.created).Seconds()), int64(c.idle().Seconds()),
+		flags, sub, multi, c.qbuf.Load(), obl, lastCmd, user, proto, libName, libVer)
+}
+
+// queue encodes r using the protocol version negotiated by the client into its output buffer.
+func (c *client) queue(r *resp.Resp) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wr.WriteValue(r, c.protover)
+}
+
+// flush writes everything queued for the client to the connection.
+func (c *client) flush() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	return c.wr.Flush()
+}
+
+// write queues r and flushes it straight away, for replies that are not part of a command batch.
+func (c *client) write(r *resp.Resp) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wr.WriteValue(r, c.protover)
+	return c.wr.Flush()
+}
+
+// validClientName reports whether name only contains printable characters without spaces, as Redis requires.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientsByID returns every connected client ordered by id.
+func (s *Server) clientsByID() []*client {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	clients := make([]*client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// validClientType reports whether t is a type CLIENT LIST and CLIENT KILL accept.
+func validClientType(t string) bool {
+	switch t {
+	case "normal", "master", "replica", "slave", "pubsub":
+		return true
+	}
+	return false
+}
+
+/*
+handleClient takes the arguments for the CLIENT command and returns a RESP response.
+CLIENT LIST [TYPE normal|master|replica|pubsub] [ID client-id ...]
+CLIENT INFO | ID | GETNAME
+CLIENT SETNAME connection-name
+CLIENT SETINFO LIB-NAME|LIB-VER value
+CLIENT KILL ip:port
+CLIENT KILL [ID client-id] [ADDR ip:port] [LADDR ip:port] [USER username] [TYPE type] [SKIPME yes|no]
+*/
+func (s *Server) handleClient(c *client, args []string) *resp.Resp {
+	sub := strings.ToUpper(args[0])
+	switch {
+	case sub == "ID" && len(args) == 1:
+		return &resp.Resp{
+			Type: resp.Integer,
+			Int:  c.id,
+		}
+
+	case sub == "INFO" && len(args) == 1:
+		return &resp.Resp{
+			Type:   resp.VerbatimString,
+			Format: "txt",
+			Str:    strPtr(s.clientInfo(c) + "\n"),
+		}
+
+	case sub == "LIST":
+		return s.clientList(args[1:])
+
+	case sub == "GETNAME" && len(args) == 1:
+		name := c.clientName()
+		if name == "" {
+			return &resp.Resp{Type: resp.Null}
+		}
+		return &resp.Resp{
+			Type: resp.BulkString,
+			Str:  &name,
+		}
+
+	case sub == "SETNAME" && len(args) == 2:
+		if !validClientName(args[1]) {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR Client names cannot contain spaces, newlines or special characters."),
+			}
+		}
+		c.setName(args[1])
+		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("OK"),
+		}
+
+	case sub == "SETINFO" && len(args) == 3:
+		attr := strings.ToLower(args[1])
+		if attr != "lib-name" && attr != "lib-ver" {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR Unrecognized option '" + args[1] + "'"),
+			}
+		}
+		if !validClientName(args[2]) {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR " + attr + " cannot contain spaces, newlines or special characters."),
+			}
+		}
+		c.mu.Lock()
+		if attr == "lib-name" {
+			c.libName = args[2]
+		} else {
+			c.libVer = args[2]
+		}
+		c.mu.Unlock()
+		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("OK"),
+		}
+
+	case sub == "KILL" && len(args) >= 2:
+		return s.clientKill(c, args[1:])
+	}
+
+	return &resp.Resp{
+		Type: resp.Error,
+		Str:  strPtr("ERR unknown subcommand or wrong number of arguments for '" + args[0] + "'"),
+	}
+}
+
+// clientList handles the arguments of CLIENT LIST that follow LIST.
+func (s *Server) clientList(args []string) *resp.Resp {
+	var typ string
+	var ids map[int64]bool
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "TYPE" && i+1 < len(args):
+			typ = strings.ToLower(args[i+1])
+			if !validClientType(typ) {
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR Unknown client type '" + args[i+1] + "'"),
+				}
+			}
+			i++
+		case opt == "ID" && i+1 < len(args):
+			ids = make(map[int64]bool)
+			for i+1 < len(args) {
+				id, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || id <= 0 {
+					return &resp.Resp{
+						Type: resp.Error,
+						Str:  strPtr("ERR Invalid client ID"),
+					}
+				}
+				ids[id] = true
+				i++
+			}
+		default:
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR syntax error"),
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, other := range s.clientsByID() {
+		if typ != "" && s.clientType(other) != typ {
+			continue
+		}
+		if ids != nil && !ids[other.id] {
+			continue
+		}
+		b.WriteString(s.clientInfo(other))
+		b.WriteString("\n")
+	}
+	return &resp.Resp{
+		Type:   resp.VerbatimString,
+		Format: "txt",
+		Str:    strPtr(b.String()),
+	}
+}
+
+/*
+clientKill handles the arguments of CLIENT KILL that follow KILL.
+The old form takes a single address and fails if no client has it, the filter form
+kills every client matching all of the filters and returns how many there were.
+The calling client is skipped unless SKIPME no is given, if it is killed it still gets its reply.
+*/
+func (s *Server) clientKill(c *client, args []string) *resp.Resp {
+	if len(args) == 1 {
+		for _, other := range s.clientsByID() {
+			if other.addr == args[0] {
+				s.killClient(c, other)
+				return &resp.Resp{
+					Type: resp.SimpleString,
+					Str:  strPtr("OK"),
+				}
+			}
+		}
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR No such client"),
+		}
+	}
+
+	if len(args)%2 != 0 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+	var (
+		id                int64
+		addr, laddr, user string
+		typ               string
+		skipme            = true
+	)
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR client-id should be greater than 0"),
+				}
+			}
+			id = n
+		case "ADDR":
+			addr = value
+		case "LADDR":
+			laddr = value
+		case "USER":
+			if !s.acl.Exists(value) {
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR No such user '" + value + "'"),
+				}
+			}
+			user = value
+		case "TYPE":
+			typ = strings.ToLower(value)
+			if !validClientType(typ) {
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR Unknown client type '" + value + "'"),
+				}
+			}
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipme = true
+			case "no":
+				skipme = false
+			default:
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR syntax error"),
+				}
+			}
+		default:
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR syntax error"),
+			}
+		}
+	}
+
+	killed := 0
+	for _, other := range s.clientsByID() {
+		switch {
+		case id != 0 && other.id != id,
+			addr != "" && other.addr != addr,
+			laddr != "" && other.laddr != laddr,
+			user != "" && other.username() != user,
+			typ != "" && s.clientType(other) != typ,
+			skipme && other == c:
+			continue
+		}
+		s.killClient(c, other)
+		killed++
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(killed),
+	}
+}
+
+// killClient disconnects victim on behalf of c, c itself is only disconnected after its reply has been sent.
+func (s *Server) killClient(c, victim *client) {
+	if victim == c {
+		c.closeAfterReply.Store(true)
+		return
+	}
+	victim.conn.Close()
+}
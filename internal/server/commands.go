@@ -1,7 +1,6 @@
 package server
 
 import (
-	"net"
 	"strconv"
 	"strings"
 
@@ -14,7 +13,7 @@ If no arguments are provided, it returns "PONG".
 If one argument is provided, it returns that argument as a bulk string.
 If more than one argument is provided, it returns an error.
 */
-func (srv *Server) handlePing(args []string) *resp.Resp {
+func (srv *Server) handlePing(c *client, args []string) *resp.Resp {
 	if len(args) == 0 {
 		return &resp.Resp{
 			Type: resp.SimpleString,
@@ -38,32 +37,119 @@ func (srv *Server) handlePing(args []string) *resp.Resp {
 
 /*
 handleEcho takes the arguments for the ECHO command and returns a RESP response.
-If no arguments are provided, it returns an error.
-If one or more arguments are provided, it concatenates them with spaces and returns the result as a bulk string.
+It returns the single message argument as a bulk string.
 */
-func (srv *Server) handleEcho(args []string) *resp.Resp {
-	if len(args) < 1 {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR nothing to ECHO"),
-		}
-	}
-
-	s := strings.Join(args, " ")
+func (srv *Server) handleEcho(c *client, args []string) *resp.Resp {
+	s := args[0]
 	return &resp.Resp{
 		Type: resp.BulkString,
 		Str:  &s,
 	}
 }
 
-func (srv *Server) handleSet(args []string) *resp.Resp {
-	if len(args) < 2 {
+/*
+handleHello takes the arguments for the HELLO command and returns a RESP response.
+HELLO [protover [AUTH username password] [SETNAME clientname]]
+If a protocol version is given the connection switches to it, only 2 and 3 are supported.
+AUTH authenticates the connection in the same round trip, like the AUTH command does.
+The reply is a map describing the server, which RESP2 clients receive as a flat array.
+*/
+func (s *Server) handleHello(c *client, args []string) *resp.Resp {
+	proto := 2
+	if c != nil {
+		proto = c.protover
+	}
+
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0])
+		if err != nil {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR Protocol version is not an integer or out of range"),
+			}
+		}
+		if ver < 2 || ver > 3 {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("NOPROTO unsupported protocol version"),
+			}
+		}
+		proto = ver
+	}
+
+	var name *string
+	var user string
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "AUTH" && i+2 < len(args):
+			if !s.acl.Authenticate(args[i+1], args[i+2]) {
+				s.logAuthFailure(c, args[i+1])
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("WRONGPASS invalid username-password pair or user is disabled."),
+				}
+			}
+			user = args[i+1]
+			i += 2
+		case opt == "SETNAME" && i+1 < len(args):
+			if !validClientName(args[i+1]) {
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR Client names cannot contain spaces, newlines or special characters."),
+				}
+			}
+			name = &args[i+1]
+			i++
+		default:
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR Syntax error in HELLO option '" + args[i] + "'"),
+			}
+		}
+	}
+
+	if user == "" && s.authRequired(c) {
 		return &resp.Resp{
 			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'set' command"),
+			Str:  strPtr("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"),
+		}
+	}
+
+	var id int64
+	if c != nil {
+		if user != "" {
+			c.login(user)
 		}
+		c.setProtover(proto)
+		if name != nil {
+			c.setName(*name)
+		}
+		id = c.id
 	}
 
+	return &resp.Resp{
+		Type: resp.Map,
+		Array: []*resp.Resp{
+			{Type: resp.BulkString, Str: strPtr("server")},
+			{Type: resp.BulkString, Str: strPtr("redis")},
+			{Type: resp.BulkString, Str: strPtr("version")},
+			{Type: resp.BulkString, Str: strPtr(serverVersion)},
+			{Type: resp.BulkString, Str: strPtr("proto")},
+			{Type: resp.Integer, Int: int64(proto)},
+			{Type: resp.BulkString, Str: strPtr("id")},
+			{Type: resp.Integer, Int: id},
+			{Type: resp.BulkString, Str: strPtr("mode")},
+			{Type: resp.BulkString, Str: strPtr("standalone")},
+			{Type: resp.BulkString, Str: strPtr("role")},
+			{Type: resp.BulkString, Str: strPtr("master")},
+			{Type: resp.BulkString, Str: strPtr("modules")},
+			{Type: resp.Array, Array: []*resp.Resp{}},
+		},
+	}
+}
+
+func (srv *Server) handleSet(c *client, args []string) *resp.Resp {
 	key := args[0]
 	val := args[1]
 	var ttl int64
@@ -80,16 +166,12 @@ func (srv *Server) handleSet(args []string) *resp.Resp {
 	}
 }
 
-func (srv *Server) handleGet(args []string) *resp.Resp {
-	if len(args) != 1 {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'get' command"),
-		}
-	}
-
+func (srv *Server) handleGet(c *client, args []string) *resp.Resp {
 	key := args[0]
-	val, ok := srv.store.Get(key)
+	val, ok, err := srv.store.Get(key)
+	if err != nil {
+		return errorReply(err)
+	}
 	if !ok {
 		return &resp.Resp{
 			Type: resp.BulkString,
@@ -103,21 +185,11 @@ func (srv *Server) handleGet(args []string) *resp.Resp {
 	}
 }
 
-func (srv *Server) handleIncr(args []string) *resp.Resp {
-	if len(args) != 1 {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'incr' command"),
-		}
-	}
-
+func (srv *Server) handleIncr(c *client, args []string) *resp.Resp {
 	key := args[0]
 	val, err := srv.store.Incr(key)
 	if err != nil {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR value is not an integer or out of range"),
-		}
+		return errorReply(err)
 	}
 
 	return &resp.Resp{
@@ -126,14 +198,7 @@ func (srv *Server) handleIncr(args []string) *resp.Resp {
 	}
 }
 
-func (s *Server) handleDel(args []string) *resp.Resp {
-	if len(args) < 1 {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'del' command"),
-		}
-	}
-
+func (s *Server) handleDel(c *client, args []string) *resp.Resp {
 	cnt := s.store.Del(args)
 
 	return &resp.Resp{
@@ -142,14 +207,7 @@ func (s *Server) handleDel(args []string) *resp.Resp {
 	}
 }
 
-func (s *Server) handleTTL(args []string) *resp.Resp {
-	if len(args) != 1 {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'ttl' command"),
-		}
-	}
-
+func (s *Server) handleTTL(c *client, args []string) *resp.Resp {
 	key := args[0]
 	ttl := s.store.TTL(key)
 
@@ -159,154 +217,47 @@ func (s *Server) handleTTL(args []string) *resp.Resp {
 	}
 }
 
-func (s *Server) handleLPush(args []string) *resp.Resp {
-	if len(args) < 2 {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'LPUSH'"),
-		}
-	}
-
-	key := args[0]
-	values := args[1:]
-
-	length := s.store.LPush(key, values...)
-
-	return &resp.Resp{
-		Type: resp.Integer,
-		Int:  int64(length),
-	}
-}
-
-func (s *Server) handleRPush(args []string) *resp.Resp {
-	if len(args) < 2 {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'RPUSH'"),
-		}
-	}
-
-	key := args[0]
-	values := args[1:]
-
-	length := s.store.RPush(key, values...)
-
-	return &resp.Resp{
-		Type: resp.Integer,
-		Int:  int64(length),
-	}
-}
-
-func (s *Server) handleLPop(args []string) *resp.Resp {
-	if len(args) != 1 {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'LPOP'"),
-		}
-	}
-
-	key := args[0]
-
-	val, ok := s.store.LPop(key)
-
-	if !ok {
-		return &resp.Resp{
-			Type: resp.BulkString,
-			Str:  nil,
-		}
-	}
-
-	return &resp.Resp{
-		Type: resp.BulkString,
-		Str:  &val,
-	}
-}
-
-func (s *Server) handleRPop(args []string) *resp.Resp {
-	if len(args) != 1 {
-		return &resp.Resp{
-			Type: resp.BulkString,
-			Str:  nil,
-		}
-	}
-
-	key := args[0]
-
-	val, ok := s.store.RPop(key)
-
-	if !ok {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR key does not exist"),
+/*
+handleObject takes the arguments for the OBJECT command and returns a RESP response.
+OBJECT ENCODING key
+It replies with the name of the internal encoding of the value at key, or null if there is none.
+*/
+func (s *Server) handleObject(c *client, args []string) *resp.Resp {
+	if strings.ToUpper(args[0]) == "ENCODING" && len(args) == 2 {
+		enc, ok := s.store.ObjectEncoding(args[1])
+		if !ok {
+			return &resp.Resp{
+				Type: resp.BulkString,
+				Str:  nil,
+			}
 		}
-	}
-
-	return &resp.Resp{
-		Type: resp.BulkString,
-		Str:  &val,
-	}
-}
-
-func (s *Server) handleLRange(args []string) *resp.Resp {
-	if len(args) != 3 {
 		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'LRANGE'"),
-		}
-	}
-
-	key := args[0]
-
-	start, err1 := strconv.Atoi(args[1])
-	stop, err2 := strconv.Atoi(args[2])
-
-	if err1 != nil || err2 != nil {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR value is not an integer or out of range"),
-		}
-	}
-
-	values := s.store.LRange(key, start, stop)
-
-	respArr := make([]*resp.Resp, len(values))
-
-	for i, v := range values {
-		val := v
-		respArr[i] = &resp.Resp{
 			Type: resp.BulkString,
-			Str:  &val,
+			Str:  &enc,
 		}
 	}
 
 	return &resp.Resp{
-		Type:  resp.Array,
-		Array: respArr,
+		Type: resp.Error,
+		Str:  strPtr("ERR unknown subcommand or wrong number of arguments for '" + args[0] + "'"),
 	}
 }
 
-func (s *Server) handleSubscribe(conn net.Conn, args []string) *resp.Resp {
-	if len(args) < 1 {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'SUBSCRIBE'"),
-		}
-	}
-
+func (s *Server) handleSubscribe(c *client, args []string) *resp.Resp {
 	for _, ch := range args {
 		// add connection to channel
 		s.pubsubMu.Lock()
 		subs, ok := s.channels[ch]
 		if !ok {
-			subs = make(map[net.Conn]bool)
+			subs = make(map[*client]bool)
 			s.channels[ch] = subs
 		}
-		subs[conn] = true
+		subs[c] = true
 
 		// count how many channels this connection is subscribed to
 		count := 0
 		for _, subscribers := range s.channels {
-			if subscribers[conn] {
+			if subscribers[c] {
 				count++
 			}
 		}
@@ -319,36 +270,28 @@ func (s *Server) handleSubscribe(conn net.Conn, args []string) *resp.Resp {
 			{Type: resp.BulkString, Str: &chName},
 			{Type: resp.Integer, Int: int64(count)},
 		}
-		conn.Write(respEncoder(&resp.Resp{
-			Type:  resp.Array,
+		c.write(&resp.Resp{
+			Type:  resp.Push,
 			Array: respArr,
-		}))
+		})
 	}
 
 	return nil
 }
 
-func (s *Server) handlePublish(args []string) *resp.Resp {
-	if len(args) != 2 {
-		return &resp.Resp{
-			Type: resp.Error,
-			Str:  strPtr("ERR wrong number of arguments for 'PUBLISH'"),
-		}
-	}
-
+func (s *Server) handlePublish(c *client, args []string) *resp.Resp {
 	channel := args[0]
 	message := args[1]
 
 	// build the message to push to each subscriber
 	notification := &resp.Resp{
-		Type: resp.Array,
+		Type: resp.Push,
 		Array: []*resp.Resp{
 			{Type: resp.BulkString, Str: strPtr("message")},
 			{Type: resp.BulkString, Str: &channel},
 			{Type: resp.BulkString, Str: &message},
 		},
 	}
-	encoded := respEncoder(notification)
 
 	s.pubsubMu.RLock()
 	subs, ok := s.channels[channel]
@@ -363,20 +306,20 @@ func (s *Server) handlePublish(args []string) *resp.Resp {
 
 	// copy subscriber connections before releasing lock
 	// so we don't hold the lock while writing to each conn
-	receivers := make([]net.Conn, 0, len(subs))
-	for conn := range subs {
-		receivers = append(receivers, conn)
+	receivers := make([]*client, 0, len(subs))
+	for sub := range subs {
+		receivers = append(receivers, sub)
 	}
 	s.pubsubMu.RUnlock()
 
 	// write to each subscriber outside the lock
 	delivered := 0
-	for _, conn := range receivers {
-		_, err := conn.Write(encoded)
+	for _, sub := range receivers {
+		err := sub.write(notification)
 		if err != nil {
 			// subscriber disconnected — remove them
 			s.pubsubMu.Lock()
-			delete(s.channels[channel], conn)
+			delete(s.channels[channel], sub)
 			s.pubsubMu.Unlock()
 		} else {
 			delivered++
@@ -390,12 +333,12 @@ func (s *Server) handlePublish(args []string) *resp.Resp {
 	}
 }
 
-func (s *Server) handleUnsubscribe(conn net.Conn, args []string) *resp.Resp {
+func (s *Server) handleUnsubscribe(c *client, args []string) *resp.Resp {
 	// if no args, unsubscribe from all channels this conn is in
 	if len(args) == 0 {
 		s.pubsubMu.Lock()
 		for ch, subs := range s.channels {
-			if subs[conn] {
+			if subs[c] {
 				args = append(args, ch)
 			}
 		}
@@ -407,7 +350,7 @@ func (s *Server) handleUnsubscribe(conn net.Conn, args []string) *resp.Resp {
 
 		// remove connection from this channel
 		if subs, ok := s.channels[ch]; ok {
-			delete(subs, conn)
+			delete(subs, c)
 			// if channel is now empty, remove it entirely
 			if len(subs) == 0 {
 				delete(s.channels, ch)
@@ -417,7 +360,7 @@ func (s *Server) handleUnsubscribe(conn net.Conn, args []string) *resp.Resp {
 		// count remaining subscriptions for this connection
 		count := 0
 		for _, subs := range s.channels {
-			if subs[conn] {
+			if subs[c] {
 				count++
 			}
 		}
@@ -429,10 +372,10 @@ func (s *Server) handleUnsubscribe(conn net.Conn, args []string) *resp.Resp {
 			{Type: resp.BulkString, Str: &chName},
 			{Type: resp.Integer, Int: int64(count)},
 		}
-		conn.Write(respEncoder(&resp.Resp{
-			Type:  resp.Array,
+		c.write(&resp.Resp{
+			Type:  resp.Push,
 			Array: respArr,
-		}))
+		})
 	}
 
 	return nil
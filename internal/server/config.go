@@ -0,0 +1,91 @@
+package server
+
+import (
+	"strings"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+/*
+handleConfig takes the arguments for the CONFIG command and returns a RESP response.
+CONFIG GET pattern [pattern ...]
+CONFIG SET parameter value [parameter value ...]
+CONFIG RESETSTAT
+CONFIG REWRITE
+*/
+func (s *Server) handleConfig(c *client, args []string) *resp.Resp {
+	sub := strings.ToUpper(args[0])
+	switch {
+	case sub == "GET" && len(args) >= 2:
+		pairs := s.cfg.Get(args[1:]...)
+		respArr := make([]*resp.Resp, 0, len(pairs)*2)
+		for _, kv := range pairs {
+			name, value := kv[0], kv[1]
+			respArr = append(respArr,
+				&resp.Resp{Type: resp.BulkString, Str: &name},
+				&resp.Resp{Type: resp.BulkString, Str: &value},
+			)
+		}
+		return &resp.Resp{
+			Type:  resp.Map,
+			Array: respArr,
+		}
+
+	case sub == "SET" && len(args) >= 3 && len(args)%2 == 1:
+		if err := s.cfg.Set(args[1:]...); err != nil {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR " + err.Error()),
+			}
+		}
+		for i := 1; i < len(args); i += 2 {
+			s.applyConfig(strings.ToLower(args[i]))
+		}
+		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("OK"),
+		}
+
+	case sub == "RESETSTAT" && len(args) == 1:
+		s.stats.reset()
+		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("OK"),
+		}
+
+	case sub == "REWRITE" && len(args) == 1:
+		if err := s.cfg.Rewrite(); err != nil {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR Rewriting config file: " + err.Error()),
+			}
+		}
+		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("OK"),
+		}
+	}
+
+	return &resp.Resp{
+		Type: resp.Error,
+		Str:  strPtr("ERR unknown subcommand or wrong number of arguments for '" + args[0] + "'"),
+	}
+}
+
+// applyConfig pushes a parameter changed by CONFIG SET to the parts of the server that cache it.
+func (s *Server) applyConfig(name string) {
+	switch name {
+	case "appendfsync":
+		if s.aof != nil {
+			s.aof.SetFsyncPolicy(s.cfg.String("appendfsync"))
+		}
+	case "requirepass":
+		s.applyRequirepass()
+	case "list-max-listpack-size":
+		s.store.SetListMaxListpackSize(int(s.cfg.Int("list-max-listpack-size")))
+	case "hash-max-listpack-entries", "hash-max-listpack-value":
+		s.store.SetHashMaxListpack(int(s.cfg.Int("hash-max-listpack-entries")), int(s.cfg.Int("hash-max-listpack-value")))
+	case "set-max-intset-entries":
+		s.store.SetSetMaxIntsetEntries(int(s.cfg.Int("set-max-intset-entries")))
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+/*
+clientsCron runs once a second until the server is closed and disconnects clients that
+have been idle for longer than the timeout setting. Like Redis, pub/sub subscribers and
+clients blocked by a blocking command are never timed out since they are expected to sit
+waiting for messages or data.
+*/
+func (s *Server) clientsCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+
+		timeout := time.Duration(s.cfg.Int("timeout")) * time.Second
+		if timeout == 0 {
+			continue
+		}
+		for _, c := range s.clientsByID() {
+			if c.idle() <= timeout || s.timeoutExempt(c) {
+				continue
+			}
+			log.Printf("Closing idle client %d", c.id)
+			c.conn.Close()
+		}
+	}
+}
+
+// timeoutExempt reports whether c may stay idle for as long as it likes.
+func (s *Server) timeoutExempt(c *client) bool {
+	return s.subscriptions(c) > 0 || c.blocked.Load()
+}
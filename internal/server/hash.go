@@ -0,0 +1,409 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+/*
+handleHSet takes the arguments for the HSET command and returns a RESP response.
+HSET key field value [field value ...]
+It replies with the number of fields that were added rather than updated.
+*/
+func (s *Server) handleHSet(c *client, args []string) *resp.Resp {
+	if len(args)%2 != 1 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR wrong number of arguments for 'hset' command"),
+		}
+	}
+
+	added, err := s.store.HSet(args[0], args[1:]...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(added),
+	}
+}
+
+/*
+handleHSetNX takes the arguments for the HSETNX command and returns a RESP response.
+HSETNX key field value
+*/
+func (s *Server) handleHSetNX(c *client, args []string) *resp.Resp {
+	set, err := s.store.HSetNX(args[0], args[1], args[2])
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolReply(set)
+}
+
+/*
+handleHGet takes the arguments for the HGET command and returns a RESP response.
+HGET key field
+*/
+func (s *Server) handleHGet(c *client, args []string) *resp.Resp {
+	val, ok, err := s.store.HGet(args[0], args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return &resp.Resp{
+			Type: resp.BulkString,
+			Str:  nil,
+		}
+	}
+	return &resp.Resp{
+		Type: resp.BulkString,
+		Str:  &val,
+	}
+}
+
+/*
+handleHMGet takes the arguments for the HMGET command and returns a RESP response.
+HMGET key field [field ...]
+*/
+func (s *Server) handleHMGet(c *client, args []string) *resp.Resp {
+	values, err := s.store.HMGet(args[0], args[1:]...)
+	if err != nil {
+		return errorReply(err)
+	}
+	reply := &resp.Resp{
+		Type:  resp.Array,
+		Array: make([]*resp.Resp, len(values)),
+	}
+	for i, v := range values {
+		reply.Array[i] = &resp.Resp{Type: resp.BulkString, Str: v}
+	}
+	return reply
+}
+
+/*
+handleHGetAll takes the arguments for the HGETALL command and returns a RESP response.
+HGETALL key
+It replies with a map of every field to its value, a flat array of both in RESP2.
+*/
+func (s *Server) handleHGetAll(c *client, args []string) *resp.Resp {
+	pairs, err := s.store.HGetAll(args[0])
+	if err != nil {
+		return errorReply(err)
+	}
+	reply := bulkArray(pairs)
+	reply.Type = resp.Map
+	return reply
+}
+
+/*
+handleHKeys takes the arguments for the HKEYS command and returns a RESP response.
+HKEYS key
+*/
+func (s *Server) handleHKeys(c *client, args []string) *resp.Resp {
+	return s.hashColumn(args[0], 0)
+}
+
+/*
+handleHVals takes the arguments for the HVALS command and returns a RESP response.
+HVALS key
+*/
+func (s *Server) handleHVals(c *client, args []string) *resp.Resp {
+	return s.hashColumn(args[0], 1)
+}
+
+// hashColumn replies with every field of the hash at key when col is 0, or every value when it is 1.
+func (s *Server) hashColumn(key string, col int) *resp.Resp {
+	pairs, err := s.store.HGetAll(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	out := make([]string, 0, len(pairs)/2)
+	for i := col; i < len(pairs); i += 2 {
+		out = append(out, pairs[i])
+	}
+	return bulkArray(out)
+}
+
+/*
+handleHDel takes the arguments for the HDEL command and returns a RESP response.
+HDEL key field [field ...]
+*/
+func (s *Server) handleHDel(c *client, args []string) *resp.Resp {
+	n, err := s.store.HDel(args[0], args[1:]...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleHExists takes the arguments for the HEXISTS command and returns a RESP response.
+HEXISTS key field
+*/
+func (s *Server) handleHExists(c *client, args []string) *resp.Resp {
+	ok, err := s.store.HExists(args[0], args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolReply(ok)
+}
+
+/*
+handleHLen takes the arguments for the HLEN command and returns a RESP response.
+HLEN key
+*/
+func (s *Server) handleHLen(c *client, args []string) *resp.Resp {
+	n, err := s.store.HLen(args[0])
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleHStrLen takes the arguments for the HSTRLEN command and returns a RESP response.
+HSTRLEN key field
+*/
+func (s *Server) handleHStrLen(c *client, args []string) *resp.Resp {
+	n, err := s.store.HStrLen(args[0], args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleHIncrBy takes the arguments for the HINCRBY command and returns a RESP response.
+HINCRBY key field increment
+*/
+func (s *Server) handleHIncrBy(c *client, args []string) *resp.Resp {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is not an integer or out of range"),
+		}
+	}
+
+	n, err := s.store.HIncrBy(args[0], args[1], delta)
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  n,
+	}
+}
+
+/*
+handleHIncrByFloat takes the arguments for the HINCRBYFLOAT command and returns a RESP response.
+HINCRBYFLOAT key field increment
+The result is logged to the AOF as an HSET, so replaying it can't give a different rounding,
+followed by an HPEXPIREAT when the field has a TTL for the HSET would clear it.
+*/
+func (s *Server) handleHIncrByFloat(c *client, args []string) *resp.Resp {
+	delta, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is not a valid float"),
+		}
+	}
+
+	val, expiresAt, err := s.store.HIncrByFloat(args[0], args[1], delta)
+	if err != nil {
+		return errorReply(err)
+	}
+	s.propagate(c, []string{"HSET", args[0], args[1], val})
+	if expiresAt > 0 {
+		s.propagate(c, []string{"HPEXPIREAT", args[0], strconv.FormatInt(expiresAt, 10), "FIELDS", "1", args[1]})
+	}
+	return &resp.Resp{
+		Type: resp.BulkString,
+		Str:  &val,
+	}
+}
+
+/*
+handleHRandField takes the arguments for the HRANDFIELD command and returns a RESP response.
+HRANDFIELD key [count [WITHVALUES]]
+Without a count it replies with a single random field, or null if the hash does not exist.
+A negative count may return the same field several times.
+*/
+func (s *Server) handleHRandField(c *client, args []string) *resp.Resp {
+	if len(args) > 3 || (len(args) == 3 && strings.ToUpper(args[2]) != "WITHVALUES") {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+
+	count := 1
+	if len(args) >= 2 {
+		n, errResp := parseRandCount(args[1])
+		if errResp != nil {
+			return errResp
+		}
+		count = n
+	}
+
+	pairs, err := s.store.HRandField(args[0], count)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if len(args) == 1 {
+		if len(pairs) == 0 {
+			return &resp.Resp{
+				Type: resp.BulkString,
+				Str:  nil,
+			}
+		}
+		return &resp.Resp{
+			Type: resp.BulkString,
+			Str:  &pairs[0],
+		}
+	}
+	if len(args) == 3 {
+		return bulkArray(pairs)
+	}
+	fields := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		fields = append(fields, pairs[i])
+	}
+	return bulkArray(fields)
+}
+
+/*
+maxRandCount bounds how many members a negative count may ask HRANDFIELD and SRANDMEMBER
+for, members that may repeat and so are not limited by the size of the key. Redis streams the reply and allows
+up to LONG_MAX/2, here it is built whole before it is sent.
+*/
+const maxRandCount = 1 << 20
+
+// parseRandCount parses the count argument of HRANDFIELD and SRANDMEMBER.
+func parseRandCount(arg string) (int, *resp.Resp) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is not an integer or out of range"),
+		}
+	}
+	if n < -maxRandCount {
+		return 0, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is out of range"),
+		}
+	}
+	return n, nil
+}
+
+/*
+handleHScan takes the arguments for the HSCAN command and returns a RESP response.
+HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+It replies with the cursor to continue from, 0 once the scan is complete, and the fields
+and values of the page.
+*/
+func (s *Server) handleHScan(c *client, args []string) *resp.Resp {
+	cursor, pattern, count, extra, errResp := parseScan(args[1:], "NOVALUES")
+	if errResp != nil {
+		return errResp
+	}
+
+	pairs, next, err := s.store.HScan(args[0], cursor, pattern, count)
+	if err != nil {
+		return errorReply(err)
+	}
+	if extra {
+		fields := make([]string, 0, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			fields = append(fields, pairs[i])
+		}
+		pairs = fields
+	}
+	return scanReply(next, pairs)
+}
+
+/*
+parseScan parses the arguments of the SCAN family from the cursor on: MATCH, COUNT and,
+for commands that have one, the flag option named extra, which is reported as set or not.
+*/
+func parseScan(args []string, extra string) (cursor uint64, pattern string, count int, extraSet bool, errResp *resp.Resp) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, "", 0, false, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR invalid cursor"),
+		}
+	}
+
+	count = 10
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "MATCH" && i+1 < len(args):
+			pattern = args[i+1]
+			i++
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return 0, "", 0, false, &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR value is not an integer or out of range"),
+				}
+			}
+			if n < 1 {
+				return 0, "", 0, false, &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR syntax error"),
+				}
+			}
+			count = n
+			i++
+		case extra != "" && opt == extra:
+			extraSet = true
+		default:
+			return 0, "", 0, false, &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR syntax error"),
+			}
+		}
+	}
+	return cursor, pattern, count, extraSet, nil
+}
+
+// scanReply builds the reply of the SCAN family, the next cursor followed by the page.
+func scanReply(next uint64, page []string) *resp.Resp {
+	return &resp.Resp{
+		Type: resp.Array,
+		Array: []*resp.Resp{
+			{Type: resp.BulkString, Str: strPtr(strconv.FormatUint(next, 10))},
+			bulkArray(page),
+		},
+	}
+}
+
+// boolReply replies 1 for true and 0 for false, the way Redis answers yes or no questions.
+func boolReply(b bool) *resp.Resp {
+	var n int64
+	if b {
+		n = 1
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  n,
+	}
+}
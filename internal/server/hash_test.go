@@ -0,0 +1,64 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHRandFieldCount(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+	expect(t, c.do("HSET", "h", "a", "1", "b", "2"), "2")
+
+	expect(t, c.do("HRANDFIELD", "h", "-1000000000000"), "-ERR value is out of range")
+	expect(t, c.do("HRANDFIELD", "h", "-"+strconv.Itoa(maxRandCount+1)), "-ERR value is out of range")
+	expect(t, c.do("HRANDFIELD", "h", "x"), "-ERR value is not an integer or out of range")
+
+	if reply := c.do("HRANDFIELD", "h", "-5", "WITHVALUES"); len(reply.Array) != 10 {
+		t.Fatalf("HRANDFIELD h -5 WITHVALUES = %s, want 5 pairs", show(reply))
+	}
+	if reply := c.do("HRANDFIELD", "h", "1000000000000"); len(reply.Array) != 2 {
+		t.Fatalf("HRANDFIELD h 1000000000000 = %s, want both fields", show(reply))
+	}
+	expect(t, c.do("HRANDFIELD", "missing", "-5"), "[]")
+}
+
+func TestHScanMatch(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+	// past hash-max-listpack-entries so the scan goes through several pages
+	args := []string{"HSET", "h"}
+	for i := 0; i < 300; i++ {
+		args = append(args, "f"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+	expect(t, c.do(args...), "300")
+
+	matched := map[string]string{}
+	cursor := "0"
+	for {
+		reply := c.do("HSCAN", "h", cursor, "MATCH", "f1*", "COUNT", "20")
+		cursor = *reply.Array[0].Str
+		pairs := reply.Array[1].Array
+		for i := 0; i < len(pairs); i += 2 {
+			matched[*pairs[i].Str] = *pairs[i+1].Str
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	// f1, f10-f19 and f100-f199
+	if len(matched) != 111 {
+		t.Fatalf("matched %d fields, want 111", len(matched))
+	}
+	for f, v := range matched {
+		if !strings.HasPrefix(f, "f1") || v != "v"+f[1:] {
+			t.Fatalf("matched %s = %s", f, v)
+		}
+	}
+
+	// a pattern with many stars on a long field neither hangs nor matches
+	long := strings.Repeat("a", 5000)
+	expect(t, c.do("HSET", "g", long, "1"), "1")
+	expect(t, c.do("HSCAN", "g", "0", "MATCH", strings.Repeat("*a", 20)+"*b"), "[0 []]")
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+	"github.com/blvckbill/redis-from-scratch/internal/store"
+)
+
+// maxFieldExpire is the latest unix time in milliseconds a field may expire at, the limit Redis has too.
+const maxFieldExpire = 1<<48 - 1
+
+/*
+handleHExpire takes the arguments for the HEXPIRE command and returns a RESP response.
+HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+*/
+func (s *Server) handleHExpire(c *client, args []string) *resp.Resp {
+	return s.hashExpire(c, args, "hexpire", 1000, false)
+}
+
+/*
+handleHPExpire takes the arguments for the HPEXPIRE command and returns a RESP response.
+HPEXPIRE key milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+*/
+func (s *Server) handleHPExpire(c *client, args []string) *resp.Resp {
+	return s.hashExpire(c, args, "hpexpire", 1, false)
+}
+
+/*
+handleHExpireAt takes the arguments for the HEXPIREAT command and returns a RESP response.
+HEXPIREAT key unix-time-seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+*/
+func (s *Server) handleHExpireAt(c *client, args []string) *resp.Resp {
+	return s.hashExpire(c, args, "hexpireat", 1000, true)
+}
+
+/*
+handleHPExpireAt takes the arguments for the HPEXPIREAT command and returns a RESP response.
+HPEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+*/
+func (s *Server) handleHPExpireAt(c *client, args []string) *resp.Resp {
+	return s.hashExpire(c, args, "hpexpireat", 1, true)
+}
+
+/*
+hashExpire implements the HEXPIRE family, unit being the milliseconds in one unit of the
+time argument and absolute telling a unix time from a TTL. It replies with a code per field:
+-2 if the field does not exist, 0 if the condition was not met, 1 if the TTL was set and 2 if
+the time was already past and the field deleted. Whatever the command, the AOF gets an
+HPEXPIREAT with the absolute time, so a replay expires the fields when they were meant to.
+*/
+func (s *Server) hashExpire(c *client, args []string, name string, unit int64, absolute bool) *resp.Resp {
+	t, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is not an integer or out of range"),
+		}
+	}
+	if t < 0 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR invalid expire time, must be >= 0"),
+		}
+	}
+	if t > maxFieldExpire/unit {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR invalid expire time in '" + name + "' command"),
+		}
+	}
+	at := t * unit
+	if !absolute {
+		at += time.Now().UnixMilli()
+		if at > maxFieldExpire {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR invalid expire time in '" + name + "' command"),
+			}
+		}
+	}
+
+	rest := args[2:]
+	cond := ""
+	if len(rest) > 0 {
+		switch opt := strings.ToUpper(rest[0]); opt {
+		case "NX", "XX", "GT", "LT":
+			cond = opt
+			rest = rest[1:]
+		}
+	}
+	fields, errResp := parseFields(rest)
+	if errResp != nil {
+		return errResp
+	}
+
+	results, err := s.store.HExpire(args[0], at, cond, fields)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	var changed []string
+	codes := make([]int64, len(results))
+	for i, r := range results {
+		codes[i] = int64(r)
+		if r == store.FieldUpdated || r == store.FieldExpired {
+			changed = append(changed, fields[i])
+		}
+	}
+	if len(changed) > 0 {
+		argv := []string{"HPEXPIREAT", args[0], strconv.FormatInt(at, 10), "FIELDS", strconv.Itoa(len(changed))}
+		s.propagate(c, append(argv, changed...))
+	}
+	return intArray(codes)
+}
+
+/*
+handleHPersist takes the arguments for the HPERSIST command and returns a RESP response.
+HPERSIST key FIELDS numfields field [field ...]
+It replies with a code per field: -2 if it does not exist, -1 if it has no TTL and 1 if the
+TTL was removed.
+*/
+func (s *Server) handleHPersist(c *client, args []string) *resp.Resp {
+	fields, errResp := parseFields(args[1:])
+	if errResp != nil {
+		return errResp
+	}
+
+	results, err := s.store.HPersist(args[0], fields)
+	if err != nil {
+		return errorReply(err)
+	}
+	codes := make([]int64, len(results))
+	for i, r := range results {
+		codes[i] = int64(r)
+	}
+	return intArray(codes)
+}
+
+/*
+handleHTTL takes the arguments for the HTTL command and returns a RESP response.
+HTTL key FIELDS numfields field [field ...]
+*/
+func (s *Server) handleHTTL(c *client, args []string) *resp.Resp {
+	return s.hashTTL(args, func(at, now int64) int64 { return (at - now + 500) / 1000 })
+}
+
+/*
+handleHPTTL takes the arguments for the HPTTL command and returns a RESP response.
+HPTTL key FIELDS numfields field [field ...]
+*/
+func (s *Server) handleHPTTL(c *client, args []string) *resp.Resp {
+	return s.hashTTL(args, func(at, now int64) int64 { return at - now })
+}
+
+/*
+handleHExpireTime takes the arguments for the HEXPIRETIME command and returns a RESP response.
+HEXPIRETIME key FIELDS numfields field [field ...]
+*/
+func (s *Server) handleHExpireTime(c *client, args []string) *resp.Resp {
+	return s.hashTTL(args, func(at, now int64) int64 { return at / 1000 })
+}
+
+/*
+handleHPExpireTime takes the arguments for the HPEXPIRETIME command and returns a RESP response.
+HPEXPIRETIME key FIELDS numfields field [field ...]
+*/
+func (s *Server) handleHPExpireTime(c *client, args []string) *resp.Resp {
+	return s.hashTTL(args, func(at, now int64) int64 { return at })
+}
+
+/*
+hashTTL implements HTTL and its siblings, which only differ in how they report the unix time
+in milliseconds a field expires at, given by conv. Fields that do not exist are -2 and fields
+without a TTL -1.
+*/
+func (s *Server) hashTTL(args []string, conv func(at, now int64) int64) *resp.Resp {
+	fields, errResp := parseFields(args[1:])
+	if errResp != nil {
+		return errResp
+	}
+
+	times, err := s.store.HExpireTime(args[0], fields)
+	if err != nil {
+		return errorReply(err)
+	}
+	now := time.Now().UnixMilli()
+	for i, at := range times {
+		if at >= 0 {
+			times[i] = max(conv(at, now), 0)
+		}
+	}
+	return intArray(times)
+}
+
+// parseFields parses the FIELDS numfields field [field ...] arguments that end the field TTL commands.
+func parseFields(args []string) ([]string, *resp.Resp) {
+	if len(args) < 2 || strings.ToUpper(args[0]) != "FIELDS" {
+		return nil, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR Mandatory argument FIELDS is missing or not at the right position"),
+		}
+	}
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || n > math.MaxInt32 {
+		return nil, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is not an integer or out of range"),
+		}
+	}
+	if n <= 0 {
+		return nil, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR Parameter `numFields` should be greater than 0"),
+		}
+	}
+	if int(n) != len(args)-2 {
+		return nil, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR The `numfields` parameter must match the number of arguments"),
+		}
+	}
+	return args[2:], nil
+}
+
+// intArray builds an array reply of integers.
+func intArray(values []int64) *resp.Resp {
+	reply := &resp.Resp{
+		Type:  resp.Array,
+		Array: make([]*resp.Resp, len(values)),
+	}
+	for i, v := range values {
+		reply.Array[i] = &resp.Resp{Type: resp.Integer, Int: v}
+	}
+	return reply
+}
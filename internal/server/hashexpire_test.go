@@ -0,0 +1,26 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHIncrByFloatKeepsTTLOnReplay(t *testing.T) {
+	aof := filepath.Join(t.TempDir(), "appendonly.aof")
+	ts := startServer(t, "--appendonly", "yes", "--appendfilename", aof)
+	c := ts.dial(t)
+	expect(t, c.do("HSET", "h", "f", "1.5", "g", "1"), "2")
+	expect(t, c.do("HPEXPIREAT", "h", "32503680000000", "FIELDS", "2", "f", "g"), "[1 1]")
+	expect(t, c.do("HINCRBYFLOAT", "h", "f", "1"), "2.5")
+	expect(t, c.do("HINCRBYFLOAT", "h", "new", "1"), "1")
+	expect(t, c.do("HPEXPIRETIME", "h", "FIELDS", "3", "f", "g", "new"), "[32503680000000 32503680000000 -1]")
+
+	c.send("SHUTDOWN")
+	c.expectClosed()
+	ts.stopped(t)
+
+	ts2 := startServer(t, "--appendonly", "yes", "--appendfilename", aof)
+	c2 := ts2.dial(t)
+	expect(t, c2.do("HGET", "h", "f"), "2.5")
+	expect(t, c2.do("HPEXPIRETIME", "h", "FIELDS", "3", "f", "g", "new"), "[32503680000000 32503680000000 -1]")
+}
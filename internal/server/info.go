@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+// infoSection is one section of the INFO reply, fields returns its lines as name/value pairs.
+type infoSection struct {
+	name   string
+	fields func(s *Server) [][2]string
+}
+
+// infoSections lists the sections INFO knows, in the order they are printed.
+var infoSections = []infoSection{
+	{"server", (*Server).infoServer},
+	{"clients", (*Server).infoClients},
+	{"stats", (*Server).infoStats},
+}
+
+func (s *Server) infoServer() [][2]string {
+	uptime := time.Since(s.started)
+	return [][2]string{
+		{"redis_version", serverVersion},
+		{"redis_mode", "standalone"},
+		{"os", runtime.GOOS + " " + runtime.GOARCH},
+		{"arch_bits", strconv.Itoa(strconv.IntSize)},
+		{"go_version", runtime.Version()},
+		{"process_id", strconv.Itoa(os.Getpid())},
+		{"tcp_port", s.cfg.String("port")},
+		{"server_time_usec", strconv.FormatInt(time.Now().UnixMicro(), 10)},
+		{"uptime_in_seconds", strconv.FormatInt(int64(uptime.Seconds()), 10)},
+		{"uptime_in_days", strconv.FormatInt(int64(uptime.Hours()/24), 10)},
+		{"config_file", s.cfg.File()},
+	}
+}
+
+func (s *Server) infoClients() [][2]string {
+	s.connMu.Lock()
+	connected := len(s.clients)
+	s.connMu.Unlock()
+
+	// a client subscribed to several channels is still a single pub/sub client
+	s.pubsubMu.RLock()
+	subscribers := make(map[*client]bool)
+	for _, subs := range s.channels {
+		for c := range subs {
+			subscribers[c] = true
+		}
+	}
+	s.pubsubMu.RUnlock()
+
+	blocked := 0
+	for _, c := range s.clientsByID() {
+		if c.blocked.Load() {
+			blocked++
+		}
+	}
+
+	return [][2]string{
+		{"connected_clients", strconv.Itoa(connected)},
+		{"maxclients", s.cfg.String("maxclients")},
+		{"pubsub_clients", strconv.Itoa(len(subscribers))},
+		{"blocked_clients", strconv.Itoa(blocked)},
+	}
+}
+
+func (s *Server) infoStats() [][2]string {
+	return [][2]string{
+		{"total_connections_received", strconv.FormatInt(s.stats.totalConnections.Load(), 10)},
+		{"total_commands_processed", strconv.FormatInt(s.stats.totalCommands.Load(), 10)},
+		{"rejected_connections", strconv.FormatInt(s.stats.rejectedConnections.Load(), 10)},
+	}
+}
+
+/*
+handleInfo takes the arguments for the INFO command and returns a RESP response.
+INFO [section ...]
+Without arguments, or with default, all or everything, every section is returned.
+Unknown sections are ignored.
+*/
+func (s *Server) handleInfo(c *client, args []string) *resp.Resp {
+	wanted := make(map[string]bool)
+	all := len(args) == 0
+	for _, arg := range args {
+		name := strings.ToLower(arg)
+		if name == "default" || name == "all" || name == "everything" {
+			all = true
+		}
+		wanted[name] = true
+	}
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(section.name[:1])+section.name[1:])
+		for _, f := range section.fields(s) {
+			fmt.Fprintf(&b, "%s:%s\r\n", f[0], f[1])
+		}
+	}
+
+	return &resp.Resp{
+		Type:   resp.VerbatimString,
+		Format: "txt",
+		Str:    strPtr(b.String()),
+	}
+}
@@ -0,0 +1,537 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+func (s *Server) handleLPush(c *client, args []string) *resp.Resp {
+	return s.push(c, "LPUSH", args, s.store.LPush)
+}
+
+func (s *Server) handleRPush(c *client, args []string) *resp.Resp {
+	return s.push(c, "RPUSH", args, s.store.RPush)
+}
+
+/*
+handleLPushX takes the arguments for the LPUSHX command and returns a RESP response.
+LPUSHX key element [element ...]
+*/
+func (s *Server) handleLPushX(c *client, args []string) *resp.Resp {
+	return s.push(c, "LPUSHX", args, s.store.LPushX)
+}
+
+/*
+handleRPushX takes the arguments for the RPUSHX command and returns a RESP response.
+RPUSHX key element [element ...]
+*/
+func (s *Server) handleRPushX(c *client, args []string) *resp.Resp {
+	return s.push(c, "RPUSHX", args, s.store.RPushX)
+}
+
+/*
+push runs one of the store's push functions and serves the clients blocked on the key. The
+push is propagated first, and all of it happens under blockMu so that no other command can
+take the new elements before the clients that were waiting for them. Inside EXEC they are
+served by handleReadyKeys once the transaction is over.
+*/
+func (s *Server) push(c *client, name string, args []string, push func(key string, values ...string) (int, error)) *resp.Resp {
+	key := args[0]
+	values := args[1:]
+
+	s.blockMu.Lock()
+	defer s.blockMu.Unlock()
+
+	length, err := push(key, values...)
+	if err != nil {
+		return errorReply(err)
+	}
+	s.propagate(c, append([]string{name}, args...))
+	s.signalReadyLocked(key)
+	if c != nil && !c.execing {
+		s.serveReadyLocked()
+	}
+
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(length),
+	}
+}
+
+/*
+handleLPop takes the arguments for the LPOP command and returns a RESP response.
+LPOP key [count]
+*/
+func (s *Server) handleLPop(c *client, args []string) *resp.Resp {
+	return s.pop(c, args, true)
+}
+
+/*
+handleRPop takes the arguments for the RPOP command and returns a RESP response.
+RPOP key [count]
+*/
+func (s *Server) handleRPop(c *client, args []string) *resp.Resp {
+	return s.pop(c, args, false)
+}
+
+// pop implements LPOP and RPOP. Without a count it replies with a single element, with one it replies with an array.
+func (s *Server) pop(c *client, args []string, left bool) *resp.Resp {
+	if len(args) > 2 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+	key := args[0]
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR value is out of range, must be positive"),
+			}
+		}
+		count = n
+	}
+
+	s.blockMu.Lock()
+	s.serveBlockedFirst(c, key)
+	items, err := s.store.PopN(key, count, left)
+	s.blockMu.Unlock()
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if len(args) == 2 {
+		// a missing key is a null array, an existing one popped with a count of 0 an empty array
+		if items == nil {
+			return &resp.Resp{Type: resp.Array, Array: nil}
+		}
+		return bulkArray(items)
+	}
+	if len(items) == 0 {
+		return &resp.Resp{
+			Type: resp.BulkString,
+			Str:  nil,
+		}
+	}
+	return &resp.Resp{
+		Type: resp.BulkString,
+		Str:  &items[0],
+	}
+}
+
+func (s *Server) handleLRange(c *client, args []string) *resp.Resp {
+	key := args[0]
+
+	start, err1 := strconv.Atoi(args[1])
+	stop, err2 := strconv.Atoi(args[2])
+
+	if err1 != nil || err2 != nil {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is not an integer or out of range"),
+		}
+	}
+
+	values, err := s.store.LRange(key, start, stop)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return bulkArray(values)
+}
+
+/*
+handleLLen takes the arguments for the LLEN command and returns a RESP response.
+LLEN key
+*/
+func (s *Server) handleLLen(c *client, args []string) *resp.Resp {
+	n, err := s.store.LLen(args[0])
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleLIndex takes the arguments for the LINDEX command and returns a RESP response.
+LINDEX key index
+*/
+func (s *Server) handleLIndex(c *client, args []string) *resp.Resp {
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is not an integer or out of range"),
+		}
+	}
+
+	val, ok, err := s.store.LIndex(args[0], index)
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return &resp.Resp{
+			Type: resp.BulkString,
+			Str:  nil,
+		}
+	}
+	return &resp.Resp{
+		Type: resp.BulkString,
+		Str:  &val,
+	}
+}
+
+/*
+handleLSet takes the arguments for the LSET command and returns a RESP response.
+LSET key index element
+*/
+func (s *Server) handleLSet(c *client, args []string) *resp.Resp {
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is not an integer or out of range"),
+		}
+	}
+
+	if err := s.store.LSet(args[0], index, args[2]); err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.SimpleString,
+		Str:  strPtr("OK"),
+	}
+}
+
+/*
+handleLInsert takes the arguments for the LINSERT command and returns a RESP response.
+LINSERT key BEFORE|AFTER pivot element
+*/
+func (s *Server) handleLInsert(c *client, args []string) *resp.Resp {
+	var before bool
+	switch strings.ToUpper(args[1]) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+	default:
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+
+	n, err := s.store.LInsert(args[0], before, args[2], args[3])
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleLRem takes the arguments for the LREM command and returns a RESP response.
+LREM key count element
+*/
+func (s *Server) handleLRem(c *client, args []string) *resp.Resp {
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is not an integer or out of range"),
+		}
+	}
+
+	n, err := s.store.LRem(args[0], count, args[2])
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleLTrim takes the arguments for the LTRIM command and returns a RESP response.
+LTRIM key start stop
+*/
+func (s *Server) handleLTrim(c *client, args []string) *resp.Resp {
+	start, err1 := strconv.Atoi(args[1])
+	stop, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR value is not an integer or out of range"),
+		}
+	}
+
+	if err := s.store.LTrim(args[0], start, stop); err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.SimpleString,
+		Str:  strPtr("OK"),
+	}
+}
+
+/*
+handleLPos takes the arguments for the LPOS command and returns a RESP response.
+LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+Without COUNT it replies with the index of the match or null, with COUNT with an array of indexes.
+*/
+func (s *Server) handleLPos(c *client, args []string) *resp.Resp {
+	rank, count, maxlen := 1, -1, 0
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR syntax error"),
+			}
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR value is not an integer or out of range"),
+			}
+		}
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if n == 0 {
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"),
+				}
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR COUNT can't be negative"),
+				}
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return &resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR MAXLEN can't be negative"),
+				}
+			}
+			maxlen = n
+		default:
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR syntax error"),
+			}
+		}
+	}
+
+	limit := count
+	if count < 0 {
+		limit = 1
+	}
+	positions, err := s.store.LPos(args[0], args[1], rank, limit, maxlen)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if count < 0 {
+		if len(positions) == 0 {
+			return &resp.Resp{Type: resp.Null}
+		}
+		return &resp.Resp{
+			Type: resp.Integer,
+			Int:  int64(positions[0]),
+		}
+	}
+	reply := &resp.Resp{
+		Type:  resp.Array,
+		Array: make([]*resp.Resp, len(positions)),
+	}
+	for i, pos := range positions {
+		reply.Array[i] = &resp.Resp{Type: resp.Integer, Int: int64(pos)}
+	}
+	return reply
+}
+
+/*
+handleLMove takes the arguments for the LMOVE command and returns a RESP response.
+LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+*/
+func (s *Server) handleLMove(c *client, args []string) *resp.Resp {
+	fromLeft, ok1 := parseDirection(args[2])
+	toLeft, ok2 := parseDirection(args[3])
+	if !ok1 || !ok2 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+	return s.move(c, append([]string{"LMOVE"}, args...), fromLeft, toLeft)
+}
+
+/*
+handleRPopLPush takes the arguments for the RPOPLPUSH command and returns a RESP response.
+RPOPLPUSH source destination
+*/
+func (s *Server) handleRPopLPush(c *client, args []string) *resp.Resp {
+	return s.move(c, append([]string{"RPOPLPUSH"}, args...), false, true)
+}
+
+/*
+move implements LMOVE and RPOPLPUSH, replying with the element moved or null if source is
+empty. Like push it propagates argv and serves the clients blocked on destination under
+blockMu, after the ones blocked on source have had their turn.
+*/
+func (s *Server) move(c *client, argv []string, fromLeft, toLeft bool) *resp.Resp {
+	src, dst := argv[1], argv[2]
+
+	s.blockMu.Lock()
+	defer s.blockMu.Unlock()
+
+	s.serveBlockedFirst(c, src)
+	item, ok, err := s.store.LMove(src, dst, fromLeft, toLeft)
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return &resp.Resp{
+			Type: resp.BulkString,
+			Str:  nil,
+		}
+	}
+	s.propagate(c, argv)
+	s.signalReadyLocked(dst)
+	if c != nil && !c.execing {
+		s.serveReadyLocked()
+	}
+	return &resp.Resp{
+		Type: resp.BulkString,
+		Str:  &item,
+	}
+}
+
+/*
+handleLMPop takes the arguments for the LMPOP command and returns a RESP response.
+LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+It pops up to count elements from the first non-empty list and replies with its key and the
+elements, or with null if every list is empty.
+*/
+func (s *Server) handleLMPop(c *client, args []string) *resp.Resp {
+	keys, left, count, errResp := parseMPop(args)
+	if errResp != nil {
+		return errResp
+	}
+	s.blockMu.Lock()
+	defer s.blockMu.Unlock()
+
+	for _, key := range keys {
+		s.serveBlockedFirst(c, key)
+		if reply, ok := s.mpop(key, left, count); ok {
+			return reply
+		}
+	}
+	return &resp.Resp{Type: resp.Array, Array: nil}
+}
+
+// mpop pops up to count elements from the list at key for LMPOP and BLMPOP, ok is false if the list is empty.
+func (s *Server) mpop(key string, left bool, count int) (*resp.Resp, bool) {
+	items, err := s.store.PopN(key, count, left)
+	if err != nil {
+		return errorReply(err), true
+	}
+	if len(items) == 0 {
+		return nil, false
+	}
+	return &resp.Resp{
+		Type: resp.Array,
+		Array: []*resp.Resp{
+			{Type: resp.BulkString, Str: strPtr(key)},
+			bulkArray(items),
+		},
+	}, true
+}
+
+// parseMPop parses the arguments LMPOP and BLMPOP share, from numkeys on.
+func parseMPop(args []string) (keys []string, left bool, count int, errResp *resp.Resp) {
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil || numkeys <= 0 {
+		return nil, false, 0, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR numkeys should be greater than 0"),
+		}
+	}
+	rest := args[1:]
+	if len(rest) < numkeys+1 {
+		return nil, false, 0, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+	keys = rest[:numkeys]
+	left, ok := parseDirection(rest[numkeys])
+	if !ok {
+		return nil, false, 0, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+	count = 1
+	switch opts := rest[numkeys+1:]; {
+	case len(opts) == 0:
+	case len(opts) == 2 && strings.ToUpper(opts[0]) == "COUNT":
+		count, err = strconv.Atoi(opts[1])
+		if err != nil || count <= 0 {
+			return nil, false, 0, &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR count should be greater than 0"),
+			}
+		}
+	default:
+		return nil, false, 0, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+	return keys, left, count, nil
+}
+
+// parseDirection parses the LEFT or RIGHT argument of the list move and pop commands.
+func parseDirection(arg string) (left bool, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// numkeysArgs returns the keys following the numkeys argument at argv[at], for commands like LMPOP.
+func numkeysArgs(argv []string, at int) []string {
+	if len(argv) <= at {
+		return nil
+	}
+	n, err := strconv.Atoi(argv[at])
+	if err != nil || n <= 0 || at+1+n > len(argv) {
+		return nil
+	}
+	return argv[at+1 : at+1+n]
+}
+
+func lmpopKeys(argv []string) []string {
+	return numkeysArgs(argv, 1)
+}
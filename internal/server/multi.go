@@ -0,0 +1,240 @@
+package server
+
+import (
+	"log"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+// runsInMulti reports whether cmd runs straight away inside MULTI instead of being queued.
+func runsInMulti(cmd *command) bool {
+	switch cmd.name {
+	case "multi", "exec", "discard", "watch":
+		return true
+	}
+	return false
+}
+
+func (c *client) inMulti() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.multi
+}
+
+// queueMulti adds argv to the commands EXEC will run.
+func (c *client) queueMulti(argv []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queued = append(c.queued, argv)
+}
+
+// flagMulti marks the transaction as failed, a command could not be queued.
+func (c *client) flagMulti() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.multiDirty = true
+}
+
+// endMulti leaves MULTI and returns the queued commands and whether one of them failed to queue.
+func (c *client) endMulti() ([][]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	queued, dirty := c.queued, c.multiDirty
+	c.multi, c.multiDirty, c.queued = false, false, nil
+	return queued, dirty
+}
+
+/*
+handleMulti takes the arguments for the MULTI command and returns a RESP response.
+Every command after it, up to EXEC or DISCARD, is queued rather than run.
+*/
+func (s *Server) handleMulti(c *client, args []string) *resp.Resp {
+	if c == nil {
+		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("OK"),
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.multi {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR MULTI calls can not be nested"),
+		}
+	}
+	c.multi = true
+	return &resp.Resp{
+		Type: resp.SimpleString,
+		Str:  strPtr("OK"),
+	}
+}
+
+/*
+handleExec takes the arguments for the EXEC command and returns a RESP response.
+It runs every queued command while holding txMu for writing, so no other client's command
+runs in between, and replies with an array of their replies. If a command failed to queue
+the whole transaction is discarded with EXECABORT instead, and if a key watched with WATCH
+changed since, it is discarded with a null reply.
+The writes of the transaction are appended to the AOF as a single MULTI ... EXEC block,
+which lets a replay recognise a transaction that was cut short. Blocking commands never
+wait inside EXEC, they reply as if they had timed out when their lists are empty.
+*/
+func (s *Server) handleExec(c *client, args []string) *resp.Resp {
+	if c == nil || !c.inMulti() {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR EXEC without MULTI"),
+		}
+	}
+	queued, dirty := c.endMulti()
+	defer s.unwatchAll(c)
+	if dirty {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("EXECABORT Transaction discarded because of previous errors."),
+		}
+	}
+
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	if s.watchedChanged(c) {
+		return &resp.Resp{Type: resp.Array, Array: nil}
+	}
+
+	replies := make([]*resp.Resp, 0, len(queued))
+	c.execing = true
+	for _, argv := range queued {
+		// the command was looked up when it was queued, but the user's permissions may have changed since
+		cmd, _ := lookupCommand(argv[0])
+		reply := s.checkACL(c, cmd, argv, "multi")
+		if reply == nil {
+			reply = cmd.handler(s, c, argv[1:])
+		}
+		if propagates(cmd, reply) {
+			s.propagate(c, argv)
+		}
+		if reply == nil {
+			reply = &resp.Resp{Type: resp.Null}
+		}
+		replies = append(replies, reply)
+	}
+	writes := c.execWrites
+	c.execing, c.execWrites = false, nil
+
+	if len(writes) > 0 {
+		block := encodeCommand([]string{"MULTI"})
+		block = append(block, writes...)
+		block = append(block, encodeCommand([]string{"EXEC"})...)
+		if err := s.aof.Append(block); err != nil {
+			log.Printf("AOF append error: %v", err)
+		}
+	}
+
+	return &resp.Resp{
+		Type:  resp.Array,
+		Array: replies,
+	}
+}
+
+/*
+handleDiscard takes the arguments for the DISCARD command and returns a RESP response.
+It drops every queued command and leaves MULTI.
+*/
+func (s *Server) handleDiscard(c *client, args []string) *resp.Resp {
+	if c == nil || !c.inMulti() {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR DISCARD without MULTI"),
+		}
+	}
+	c.endMulti()
+	s.unwatchAll(c)
+	return &resp.Resp{
+		Type: resp.SimpleString,
+		Str:  strPtr("OK"),
+	}
+}
+
+// watchedChanged reports whether any key c watches has changed or expired since it was watched.
+func (s *Server) watchedChanged(c *client) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, version := range c.watched {
+		if s.store.Version(key) != version {
+			return true
+		}
+	}
+	return false
+}
+
+// unwatchAll forgets every key c watches.
+func (s *Server) unwatchAll(c *client) {
+	c.mu.Lock()
+	watched := c.watched
+	c.watched = nil
+	c.mu.Unlock()
+
+	for key := range watched {
+		s.store.Unwatch(key)
+	}
+}
+
+/*
+handleWatch takes the arguments for the WATCH command and returns a RESP response.
+WATCH key [key ...]
+The next EXEC of the client fails if any of the keys changes before it runs.
+*/
+func (s *Server) handleWatch(c *client, args []string) *resp.Resp {
+	if c == nil {
+		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("OK"),
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.multi {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR WATCH inside MULTI is not allowed"),
+		}
+	}
+	if c.watched == nil {
+		c.watched = make(map[string]uint64)
+	}
+	for _, key := range args {
+		if _, ok := c.watched[key]; ok {
+			continue
+		}
+		c.watched[key] = s.store.Watch(key)
+	}
+	return &resp.Resp{
+		Type: resp.SimpleString,
+		Str:  strPtr("OK"),
+	}
+}
+
+/*
+handleUnwatch takes the arguments for the UNWATCH command and returns a RESP response.
+It forgets every key the client watches.
+*/
+func (s *Server) handleUnwatch(c *client, args []string) *resp.Resp {
+	if c != nil {
+		s.unwatchAll(c)
+	}
+	return &resp.Resp{
+		Type: resp.SimpleString,
+		Str:  strPtr("OK"),
+	}
+}
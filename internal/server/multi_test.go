@@ -0,0 +1,68 @@
+package server
+
+import "testing"
+
+func TestExec(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+
+	expect(t, c.do("MULTI"), "OK")
+	expect(t, c.do("SET", "k", "1"), "QUEUED")
+	expect(t, c.do("INCR", "k"), "QUEUED")
+	expect(t, c.do("EXEC"), "[OK 2]")
+}
+
+func TestExecAbortsWhenWatchedKeyChanges(t *testing.T) {
+	ts := startServer(t)
+	a, b := ts.dial(t), ts.dial(t)
+
+	expect(t, a.do("SET", "k", "1"), "OK")
+	expect(t, a.do("WATCH", "k"), "OK")
+	expect(t, b.do("SET", "k", "2"), "OK")
+	expect(t, a.do("MULTI"), "OK")
+	expect(t, a.do("SET", "k", "3"), "QUEUED")
+	expect(t, a.do("EXEC"), "nil")
+	expect(t, a.do("GET", "k"), "2")
+
+	// EXEC unwatches everything, so the next transaction goes through
+	expect(t, a.do("MULTI"), "OK")
+	expect(t, a.do("SET", "k", "3"), "QUEUED")
+	expect(t, a.do("EXEC"), "[OK]")
+	expect(t, a.do("GET", "k"), "3")
+}
+
+func TestExecRunsWhenWatchedKeyUnchanged(t *testing.T) {
+	ts := startServer(t)
+	a, b := ts.dial(t), ts.dial(t)
+
+	expect(t, a.do("WATCH", "k"), "OK")
+	// reads and writes to other keys don't count as changes
+	expect(t, b.do("GET", "k"), "nil")
+	expect(t, b.do("SET", "other", "x"), "OK")
+	expect(t, a.do("MULTI"), "OK")
+	expect(t, a.do("SET", "k", "1"), "QUEUED")
+	expect(t, a.do("EXEC"), "[OK]")
+}
+
+func TestUnwatch(t *testing.T) {
+	ts := startServer(t)
+	a, b := ts.dial(t), ts.dial(t)
+
+	expect(t, a.do("WATCH", "k"), "OK")
+	expect(t, a.do("UNWATCH"), "OK")
+	expect(t, b.do("SET", "k", "2"), "OK")
+	expect(t, a.do("MULTI"), "OK")
+	expect(t, a.do("GET", "k"), "QUEUED")
+	expect(t, a.do("EXEC"), "[2]")
+}
+
+func TestExecAbortsAfterQueueingError(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+
+	expect(t, c.do("MULTI"), "OK")
+	expect(t, c.do("SET", "k", "1"), "QUEUED")
+	expect(t, c.do("NOSUCHCMD"), "-ERR unknown command 'NOSUCHCMD', with args beginning with: ")
+	expect(t, c.do("EXEC"), "-EXECABORT Transaction discarded because of previous errors.")
+	expect(t, c.do("GET", "k"), "nil")
+}
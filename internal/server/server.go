@@ -1,283 +1,512 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"strconv"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/blvckbill/redis-from-scratch/internal/acl"
+	"github.com/blvckbill/redis-from-scratch/internal/config"
 	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
 	"github.com/blvckbill/redis-from-scratch/internal/store"
 )
 
+// serverVersion is the Redis version reported to clients in HELLO.
+const serverVersion = "7.2.0"
+
 type Server struct {
-	store       *store.Store
-	aof         *AOFLogger
-	channels    map[string]map[net.Conn]bool
-	pubsubMu    sync.RWMutex
-	isReplaying bool
+	cfg          *config.Config
+	store        *store.Store
+	aof          *AOFLogger // nil when appendonly is off
+	acl          *acl.ACL
+	channels     map[string]map[*client]bool
+	pubsubMu     sync.RWMutex
+	isReplaying  bool
+	nextClientID atomic.Int64
+	stats        stats
+	started      time.Time
+
+	// listeners and connected clients, tracked so Close can shut them down
+	connMu    sync.Mutex
+	listeners []net.Listener
+	clients   map[int64]*client
+	clientsWG sync.WaitGroup
+	inflight  atomic.Int64 // commands being executed right now
+
+	// txMu is held for reading by every command and for writing by EXEC, so the
+	// commands of a transaction run without any other client's commands in between
+	txMu sync.RWMutex
+
+	// clients parked by blocking commands, queued per key in the order they blocked, and the
+	// keys pushed to since they were last served
+	blockMu   sync.Mutex
+	blocked   map[string][]*blockedClient
+	readyKeys []string
+	readySet  map[string]bool
+
+	shutdownMu    sync.Mutex
+	shutdownAbort chan struct{} // non-nil while SHUTDOWN is waiting, closed by SHUTDOWN ABORT
+	closing       atomic.Bool
+	closeOnce     sync.Once
+	closeErr      error
+	closed        chan struct{} // closed once Close has finished
+}
+
+// stats are the counters reported by INFO and reset by CONFIG RESETSTAT.
+type stats struct {
+	totalConnections    atomic.Int64
+	totalCommands       atomic.Int64
+	rejectedConnections atomic.Int64 // connections refused because of maxclients
+}
+
+func (st *stats) reset() {
+	st.totalConnections.Store(0)
+	st.totalCommands.Store(0)
+	st.rejectedConnections.Store(0)
 }
 
-func NewServer() *Server {
+func NewServer(cfg *config.Config) *Server {
 	var db = store.NewStore()
-	aofLogger, err := NewAOFLogger("appendonly.aof")
-	channels := make(map[string]map[net.Conn]bool)
-	if err != nil {
-		log.Fatalf("Fatal: could not create AOF logger: %v", err)
-	}
+	db.SetListMaxListpackSize(int(cfg.Int("list-max-listpack-size")))
+	db.SetHashMaxListpack(int(cfg.Int("hash-max-listpack-entries")), int(cfg.Int("hash-max-listpack-value")))
+	db.SetSetMaxIntsetEntries(int(cfg.Int("set-max-intset-entries")))
+	channels := make(map[string]map[*client]bool)
 
 	s := &Server{
+		cfg:      cfg,
 		store:    db,
-		aof:      aofLogger,
 		channels: channels,
+		clients:  make(map[int64]*client),
+		blocked:  make(map[string][]*blockedClient),
+		readySet: make(map[string]bool),
+		closed:   make(chan struct{}),
+		acl:      newACL(),
+		started:  time.Now(),
+	}
+
+	if path := cfg.String("aclfile"); path != "" {
+		if err := s.acl.LoadFile(path); err != nil {
+			log.Fatalf("Fatal: could not load ACL file: %v", err)
+		}
+	}
+	if cfg.String("requirepass") != "" {
+		s.applyRequirepass()
+	}
+
+	if cfg.Bool("appendonly") {
+		aofLogger, err := NewAOFLogger(cfg.String("appendfilename"), cfg.String("appendfsync"))
+		if err != nil {
+			log.Fatalf("Fatal: could not create AOF logger: %v", err)
+		}
+		s.aof = aofLogger
+
+		s.isReplaying = true
+		if err := aofLogger.Replay(s); err != nil {
+			log.Fatalf("Fatal: could not load AOF: %v", err)
+		}
+		s.isReplaying = false
 	}
-	s.isReplaying = true
-	aofLogger.Replay(s)
-	s.isReplaying = false
 
 	return s
 }
 
-func (s *Server) Start() {
-	addr := "127.0.0.1:6369"
-	// Create a TCP listening socket bound to the address.
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		// if there is an error, fail fast like redis does
-		log.Fatalf("Fatal: could not start listener: %v", err)
+// limits returns the protocol limits currently configured for client connections.
+func (s *Server) limits() resp.Limits {
+	return resp.Limits{
+		MaxBulkLen:       int(s.cfg.Int("proto-max-bulk-len")),
+		MaxMultibulkLen:  int(s.cfg.Int("proto-max-multibulk-len")),
+		QueryBufferLimit: int(s.cfg.Int("client-query-buffer-limit")),
 	}
+}
 
-	defer ln.Close()
+/*
+Start listens on the configured plain TCP and TLS ports and Unix socket and serves clients
+until the server is closed, either by Close, a SHUTDOWN command or a signal handled by the
+caller. A port of 0 disables that listener. It returns nil once the shutdown has completed.
+*/
+func (s *Server) Start() error {
+	var lns []net.Listener
+	fail := func(err error) error {
+		for _, ln := range lns {
+			ln.Close()
+		}
+		return err
+	}
 
-	log.Printf("GoRedis is listening on %s", addr)
+	bind := s.cfg.String("bind")
+	if port := s.cfg.String("port"); port != "0" {
+		// Create a TCP listening socket bound to the address.
+		ln, err := net.Listen("tcp", net.JoinHostPort(bind, port))
+		if err != nil {
+			return fail(err)
+		}
+		lns = append(lns, ln)
+	}
+	if port := s.cfg.String("tls-port"); port != "0" {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return fail(fmt.Errorf("failed to configure TLS: %w", err))
+		}
+		ln, err := tls.Listen("tcp", net.JoinHostPort(bind, port), tlsConfig)
+		if err != nil {
+			return fail(err)
+		}
+		lns = append(lns, ln)
+	}
+	if path := s.cfg.String("unixsocket"); path != "" {
+		ln, err := listenUnix(path, os.FileMode(s.cfg.Octal("unixsocketperm")))
+		if err != nil {
+			return fail(err)
+		}
+		lns = append(lns, ln)
+	}
+	if len(lns) == 0 {
+		return errors.New("no listeners configured, set port, tls-port or unixsocket")
+	}
 
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		if !s.addListener(ln) {
+			fail(nil)
+			<-s.closed
+			return nil
+		}
+		log.Printf("GoRedis is listening on %s", ln.Addr())
+		go func(ln net.Listener) {
+			errs <- s.serve(ln)
+		}(ln)
+	}
+
+	go s.clientsCron()
+
+	for range lns {
+		if err := <-errs; err != nil {
+			s.Close()
+			return err
+		}
+	}
+	// every listener was closed by a shutdown, wait for it to finish
+	<-s.closed
+	return nil
+}
+
+/*
+listenUnix listens on a Unix socket at path, replacing a socket left behind by a previous run.
+A non-zero perm is applied to the socket file, like unixsocketperm in Redis.
+*/
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// setKeepAlive turns on TCP keepalive probes every period seconds, or off for 0. Unix sockets are left alone.
+func setKeepAlive(conn net.Conn, period int64) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if period == 0 {
+		tcp.SetKeepAlive(false)
+		return
+	}
+	tcp.SetKeepAlive(true)
+	tcp.SetKeepAlivePeriod(time.Duration(period) * time.Second)
+}
+
+// serve accepts connections on ln until it is closed, it returns nil if it was closed by a shutdown.
+func (s *Server) serve(ln net.Listener) error {
 	// create a loop to wait for an Accept on the listener
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if s.closing.Load() {
+				return nil
+			}
 			// Hnadle Accept error
 			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
 				fmt.Printf("Timeout occurred, just waiting for the next caller...")
 				continue
 			}
-			log.Fatalf("Accept error: %v", err)
+			return err
 		}
+		setKeepAlive(conn, s.cfg.Int("tcp-keepalive"))
 		// once there is a connection, hand it off to another process using go concurrency so bloacking is avoided
-		go s.handleConnection(conn)
+		c, err := s.addClient(conn)
+		if err == errMaxClients {
+			s.stats.rejectedConnections.Add(1)
+			// written from its own goroutine so a slow client can't hold up the accept loop
+			go rejectClient(conn)
+			continue
+		}
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		go s.handleConnection(c)
+	}
+}
+
+// Addr returns the address of the first listener, or nil if the server is not listening yet.
+func (s *Server) Addr() net.Addr {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	if len(s.listeners) == 0 {
+		return nil
 	}
+	return s.listeners[0].Addr()
 }
 
-func (s *Server) handleConnection(conn net.Conn) {
-	defer conn.Close()
+// addListener records a listener so Close can stop it, it returns false if the server is already closing.
+func (s *Server) addListener(ln net.Listener) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	if s.closing.Load() {
+		return false
+	}
+	s.listeners = append(s.listeners, ln)
+	return true
+}
+
+func (s *Server) handleConnection(c *client) {
+	defer s.removeClient(c)
 	fmt.Println("Connection established successfully")
-	// create a buffer to read data from the connection and write it back to the client
-	readBuf := make([]byte, 4096)
-	var buffer []byte
-	// create a loop to read from the connection into the buffer and write back to the client
+	rd := resp.NewReader(c.conn)
+	c.rd = rd
+	s.stats.totalConnections.Add(1)
+	// read commands off the connection one at a time, replies are queued in the client's
+	// output buffer and flushed once every pipelined command that arrived has been run
 	for {
-		n, err := conn.Read(readBuf)
+		// limits are picked up on every command so CONFIG SET applies to connected clients too
+		rd.SetLimits(s.limits())
+		args, err := rd.ReadCommand()
 		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
+			var perr *resp.ProtocolError
+			if errors.As(err, &perr) {
+				// the input can never become a valid command, reply with the error and drop the client like Redis does
+				log.Printf("Closing client %d: %v", c.id, err)
+				c.write(&resp.Resp{
+					Type: resp.Error,
+					Str:  strPtr("ERR " + err.Error()),
+				})
+			} else if err != io.EOF && !errors.Is(err, net.ErrClosed) {
 				log.Printf("Connection disconnected or error: %v", err)
-				break
 			}
+			return
 		}
-		// append the read data to the buffer and try to parse it as RESP
-		buffer = append(buffer, readBuf[:n]...)
-		for {
-			// Try to parse the buffer as RESP, if successful, execute the command and write the response back to the client
-			parsedResp, consumed, ok := resp.Parser(buffer)
-			if !ok {
-				break
-			}
-			// Remove the parsed command from the buffer
-			buffer = buffer[consumed:]
-
-			fmt.Printf("Parsed RESP: %+v\n", parsedResp)
-			// Execute the command and write the response back to the client
-			parsed, ok := ParsedRespToStrings(parsedResp)
-			if !ok {
-				log.Printf("Error parsing RESP to strings")
-				return
-			}
-			response := s.commandExecution(conn, parsed)
 
-			if conn != nil && response != nil {
-				bytes_parsed := respEncoder(response)
-
-				_, err := conn.Write(bytes_parsed)
-				if err != nil {
-					log.Printf("Error writing to connection: %v", err)
-					return
-				}
+		c.qbuf.Store(int64(rd.Buffered()))
+		argv := argsToStrings(args)
+		// Execute the command and write the response back to the client
+		s.stats.totalCommands.Add(1)
+		s.inflight.Add(1)
+		response := s.commandExecution(c, argv)
+		s.inflight.Add(-1)
+		if response != nil {
+			c.queue(response)
+		}
 
-				fmt.Printf("Sent response: %s", string(bytes_parsed))
+		if rd.Buffered() == 0 || c.closeAfterReply.Load() {
+			if err := c.flush(); err != nil {
+				log.Printf("Error writing to connection: %v", err)
+				return
 			}
 		}
+		if c.closeAfterReply.Load() {
+			return
+		}
 	}
 }
 
 /*
 commandExecution takes a slice of strings representing the command and its arguments,
-executes the command, and returns a RESP response.
+looks the command up in the command table, checks its arity and that the client is allowed
+to run it, executes it and returns a RESP response.
+Inside MULTI the command is queued instead, and a failed check aborts the transaction.
+Successful write commands are appended to the AOF.
 */
-func (s *Server) commandExecution(conn net.Conn, argv []string) *resp.Resp {
+func (s *Server) commandExecution(c *client, argv []string) *resp.Resp {
 	if len(argv) == 0 {
 		return nil
 	}
 
-	cmd := strings.ToUpper(argv[0])
-
-	var response *resp.Resp
-	switch cmd { // refactor to use interfaces
-	case "PING":
-		return s.handlePing(argv[1:])
-	case "ECHO":
-		return s.handleEcho(argv[1:])
-	case "SET":
-		response = s.handleSet(argv[1:])
-	case "GET":
-		return s.handleGet(argv[1:])
-	case "DEL":
-		response = s.handleDel(argv[1:])
-	case "INCR":
-		response = s.handleIncr(argv[1:])
-	case "TTL":
-		return s.handleTTL(argv[1:])
-	case "LPUSH":
-		response = s.handleLPush(argv[1:])
-	case "RPUSH":
-		response = s.handleRPush(argv[1:])
-	case "LPOP":
-		response = s.handleLPop(argv[1:])
-	case "RPOP":
-		response = s.handleRPop(argv[1:])
-	case "LRANGE":
-		return s.handleLRange(argv[1:])
-	case "SUBSCRIBE":
-		return s.handleSubscribe(conn, argv[1:])
-	case "PUBLISH":
-		response = s.handlePublish(argv[1:])
-	case "UNSUBSCRIBE":
-		return s.handleUnsubscribe(conn, argv[1:])
-	default:
+	cmd, errResp := s.checkCommand(c, argv)
+	if errResp != nil {
+		if c != nil && c.inMulti() {
+			c.flagMulti()
+		}
+		return errResp
+	}
+
+	if c != nil && c.inMulti() && !runsInMulti(cmd) {
+		c.queueMulti(argv)
 		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("QUEUED"),
+		}
+	}
+	return s.call(c, cmd, argv)
+}
+
+// checkCommand looks the command up and checks its arity, authentication and ACL permissions, returning the error to reply with if any fails.
+func (s *Server) checkCommand(c *client, argv []string) (*command, *resp.Resp) {
+	cmd, ok := lookupCommand(argv[0])
+	if !ok {
+		return nil, &resp.Resp{
 			Type: resp.Error,
-			Str:  strPtr("ERR unknown command"),
+			Str:  strPtr(unknownCommandError(argv)),
 		}
 	}
-	if response.Type != resp.Error {
-		cmdBytes := encodeCommand(argv)
-		if !s.isReplaying {
-			if err := s.aof.Append(cmdBytes); err != nil {
-				log.Printf("AOF append error: %v", err)
-			}
+	if !cmd.arityOK(len(argv)) {
+		return nil, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR wrong number of arguments for '" + cmd.name + "' command"),
 		}
 	}
 
-	return response
-}
+	if c != nil {
+		name := cmd.name
+		if cmd.container && len(argv) > 1 {
+			name += "|" + strings.ToLower(argv[1])
+		}
+		c.touch(name)
+	}
 
-func encodeCommand(argv []string) []byte {
-	r := &resp.Resp{
-		Type:  resp.Array,
-		Array: make([]*resp.Resp, len(argv)),
+	if cmd.flags&flagNoAuth == 0 && s.authRequired(c) {
+		return nil, &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("NOAUTH Authentication required."),
+		}
 	}
-	for i, arg := range argv {
-		s := arg
-		r.Array[i] = &resp.Resp{
-			Type: resp.BulkString,
-			Str:  &s,
+	if cmd.flags&flagNoAuth == 0 && c != nil {
+		if err := s.checkACL(c, cmd, argv, "toplevel"); err != nil {
+			return nil, err
 		}
 	}
-	return respEncoder(r)
-}
-
-// strPtr is a helper function to create a pointer to a string literal.
-func strPtr(s string) *string {
-	return &s
+	return cmd, nil
 }
 
 /*
-respToString takes a RESP object and converts it to a string if possible.
-It returns the string and a boolean indicating whether the conversion was successful.
-Only RESP types that can be represented as strings (BulkString, SimpleString, Integer) are converted.
-Other types will return an empty string and false.
+call runs a command that passed every check and appends it to the AOF if it was a
+successful write. Commands run under a read lock on txMu so they never interleave with
+the commands of a transaction; EXEC takes the write lock itself, blocking commands
+take the read lock only while they are not waiting, and SHUTDOWN, which waits for the
+other commands to finish, takes none.
+Clients blocked on keys the command pushed to are served once it has been propagated.
 */
-func respToString(r *resp.Resp) (string, bool) {
-	switch r.Type {
-	case resp.BulkString, resp.SimpleString:
-		if r.Str == nil {
-			return "", false
-		}
-		return *r.Str, true
+func (s *Server) call(c *client, cmd *command, argv []string) *resp.Resp {
+	response := s.run(c, cmd, argv)
+	s.handleReadyKeys()
+	return response
+}
 
-	case resp.Integer:
-		return fmt.Sprintf("%d", r.Int), true
+func (s *Server) run(c *client, cmd *command, argv []string) *resp.Resp {
+	if cmd.name != "exec" && cmd.name != "shutdown" && cmd.flags&flagBlocking == 0 {
+		s.txMu.RLock()
+		defer s.txMu.RUnlock()
+	}
 
-	default:
-		return "", false
+	response := cmd.handler(s, c, argv[1:])
+
+	if propagates(cmd, response) {
+		s.propagate(c, argv)
 	}
+
+	return response
 }
 
-/*
-ParsedRespToStrings takes a parsed RESP command and converts
-it to a slice of strings representing the command and its arguments.
-*/
-func ParsedRespToStrings(r *resp.Resp) ([]string, bool) {
-	var argv []string
-	for _, arg := range r.Array {
-		s, ok := respToString(arg)
-		if !ok {
-			log.Printf("Error converting RESP to string: %v", arg)
-			return nil, false
-		}
-		argv = append(argv, s)
-	}
-	return argv, true
+// propagates reports whether a command that replied with response changed the dataset and belongs in the AOF as it was sent.
+func propagates(cmd *command, response *resp.Resp) bool {
+	return cmd.flags&flagWrite != 0 && !cmd.propagatesItself && response != nil && response.Type != resp.Error
 }
 
 /*
-respEncoder takes a RESP object and encodes it into a byte slice that can be sent back to the client.
-It handles all RESP types (SimpleString, Error, Integer, BulkString, Array) and returns the appropriate RESP format as bytes.
-If an unknown RESP type is encountered, it returns an error message in RESP format.
+propagate appends a command run for c to the AOF, or to the writes of its transaction
+inside EXEC. c is nil for commands run on behalf of a client that was blocked.
 */
-func respEncoder(r *resp.Resp) []byte {
-	switch r.Type {
-
-	case resp.SimpleString:
-		return []byte("+" + *r.Str + "\r\n")
-
-	case resp.Error:
-		return []byte("-" + *r.Str + "\r\n")
-
-	case resp.Integer:
-		return []byte(":" + strconv.FormatInt(r.Int, 10) + "\r\n")
+func (s *Server) propagate(c *client, argv []string) {
+	if s.aof == nil || s.isReplaying {
+		return
+	}
+	if c != nil && c.execing {
+		c.execWrites = append(c.execWrites, encodeCommand(argv)...)
+		return
+	}
+	if err := s.aof.Append(encodeCommand(argv)); err != nil {
+		log.Printf("AOF append error: %v", err)
+	}
+}
 
-	case resp.BulkString:
-		if r.Str == nil {
-			return []byte("$-1\r\n")
+// unknownCommandError builds the error Redis gives for an unknown command, quoting the first few arguments.
+func unknownCommandError(argv []string) string {
+	var b strings.Builder
+	b.WriteString("ERR unknown command '" + argv[0] + "', with args beginning with: ")
+	for i, arg := range argv[1:] {
+		if i == 16 {
+			break
 		}
-		s := *r.Str
-		return []byte("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+		b.WriteString("'" + arg + "' ")
+	}
+	return b.String()
+}
 
-	case resp.Array:
-		if r.Array == nil {
-			return []byte("*-1\r\n")
+func encodeCommand(argv []string) []byte {
+	r := &resp.Resp{
+		Type:  resp.Array,
+		Array: make([]*resp.Resp, len(argv)),
+	}
+	for i, arg := range argv {
+		s := arg
+		r.Array[i] = &resp.Resp{
+			Type: resp.BulkString,
+			Str:  &s,
 		}
-		var out []byte
+	}
+	return resp.AppendValue(nil, r, 2)
+}
 
-		out = append(out, []byte("*"+strconv.Itoa(len(r.Array))+"\r\n")...)
+// errorReply turns an error returned by the store, whose message carries its Redis error code, into an error reply.
+func errorReply(err error) *resp.Resp {
+	return &resp.Resp{
+		Type: resp.Error,
+		Str:  strPtr(err.Error()),
+	}
+}
 
-		for _, el := range r.Array {
-			out = append(out, respEncoder(el)...)
-		}
-		return out
+// strPtr is a helper function to create a pointer to a string literal.
+func strPtr(s string) *string {
+	return &s
+}
+
+// argsToStrings copies the arguments read by a resp.Reader into the argv form used by the command handlers.
+func argsToStrings(args [][]byte) []string {
+	argv := make([]string, len(args))
+	for i, arg := range args {
+		argv[i] = string(arg)
 	}
-	return []byte("-ERR unknown RESP type\r\n")
+	return argv
 }
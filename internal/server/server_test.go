@@ -0,0 +1,279 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blvckbill/redis-from-scratch/internal/config"
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+// testServer is a server started for a test, listening on a Unix socket in a temporary directory.
+type testServer struct {
+	*Server
+	sock string
+	done chan error // receives what Start returned
+}
+
+/*
+startServer starts a server with the AOF off and no TCP port, args are extra --name value
+overrides. The server is closed when the test ends.
+*/
+func startServer(t *testing.T, args ...string) *testServer {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "goredis.sock")
+	cfg, err := config.Load(append([]string{"--port", "0", "--unixsocket", sock, "--appendonly", "no"}, args...))
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+
+	ts := &testServer{Server: NewServer(cfg), sock: sock, done: make(chan error, 1)}
+	go func() { ts.done <- ts.Start() }()
+	t.Cleanup(func() { ts.Close() })
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			conn.Close()
+			return ts
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start listening")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// stopped waits for Start to return and returns its error.
+func (ts *testServer) stopped(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-ts.done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return")
+		return nil
+	}
+}
+
+// testConn is a client connection speaking RESP2.
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	rd   *resp.Reader
+}
+
+func (ts *testServer) dial(t *testing.T) *testConn {
+	t.Helper()
+	conn, err := net.Dial("unix", ts.sock)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testConn{t: t, conn: conn, rd: resp.NewReader(conn)}
+}
+
+// send writes a command without waiting for its reply.
+func (tc *testConn) send(args ...string) {
+	tc.t.Helper()
+	if _, err := tc.conn.Write(encodeCommand(args)); err != nil {
+		tc.t.Fatalf("write %v: %v", args, err)
+	}
+}
+
+// read reads the next reply, failing the test if none arrives within a few seconds.
+func (tc *testConn) read() *resp.Resp {
+	tc.t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := tc.rd.ReadValue()
+	if err != nil {
+		tc.t.Fatalf("read: %v", err)
+	}
+	return reply
+}
+
+// do sends a command and returns its reply.
+func (tc *testConn) do(args ...string) *resp.Resp {
+	tc.t.Helper()
+	tc.send(args...)
+	return tc.read()
+}
+
+// expectClosed fails the test unless the server closes the connection.
+func (tc *testConn) expectClosed() {
+	tc.t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if reply, err := tc.rd.ReadValue(); err == nil {
+		tc.t.Fatalf("got %s, want the connection closed", show(reply))
+	} else if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, net.ErrClosed) {
+		tc.t.Fatalf("read error %v, want the connection closed", err)
+	}
+}
+
+// show renders a reply compactly for test messages and comparisons: strings bare, errors with a '-', nulls as nil.
+func show(r *resp.Resp) string {
+	switch r.Type {
+	case resp.SimpleString, resp.BulkString:
+		if r.Str == nil {
+			return "nil"
+		}
+		return *r.Str
+	case resp.Error:
+		return "-" + *r.Str
+	case resp.Integer:
+		return strconv.FormatInt(r.Int, 10)
+	case resp.Null:
+		return "nil"
+	default:
+		if r.Array == nil {
+			return "nil"
+		}
+		items := make([]string, len(r.Array))
+		for i, item := range r.Array {
+			items[i] = show(item)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+}
+
+func expect(t *testing.T, reply *resp.Resp, want string) {
+	t.Helper()
+	if got := show(reply); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestCloseStopsServer(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+	expect(t, c.do("SET", "k", "v"), "OK")
+
+	if err := ts.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := ts.stopped(t); err != nil {
+		t.Fatalf("Start returned %v after Close", err)
+	}
+	c.expectClosed()
+	if conn, err := net.Dial("unix", ts.sock); err == nil {
+		conn.Close()
+		t.Fatal("server still accepts connections after Close")
+	}
+	// a second Close is harmless and returns the same result
+	if err := ts.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestShutdownFlushesAOF(t *testing.T) {
+	aof := filepath.Join(t.TempDir(), "appendonly.aof")
+	ts := startServer(t, "--appendonly", "yes", "--appendfilename", aof, "--appendfsync", "no")
+	c := ts.dial(t)
+	expect(t, c.do("SET", "k", "v"), "OK")
+	expect(t, c.do("RPUSH", "l", "a", "b"), "2")
+
+	// SHUTDOWN sends no reply on success, the connection just goes away
+	c.send("SHUTDOWN")
+	c.expectClosed()
+	if err := ts.stopped(t); err != nil {
+		t.Fatalf("Start returned %v after SHUTDOWN", err)
+	}
+
+	data, err := os.ReadFile(aof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "RPUSH") {
+		t.Fatalf("AOF is missing the writes:\n%q", data)
+	}
+
+	// a new server replays the AOF
+	ts2 := startServer(t, "--appendonly", "yes", "--appendfilename", aof)
+	c2 := ts2.dial(t)
+	expect(t, c2.do("GET", "k"), "v")
+	expect(t, c2.do("LRANGE", "l", "0", "-1"), "[a b]")
+}
+
+func TestShutdownAbort(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+	expect(t, c.do("SHUTDOWN", "ABORT"), "-ERR No shutdown in progress.")
+	expect(t, c.do("PING"), "PONG")
+}
+
+func TestShutdownWaitDoesNotStallClients(t *testing.T) {
+	ts := startServer(t, "--shutdown-timeout", "10")
+	// a command still running keeps the shutdown waiting
+	ts.inflight.Add(1)
+	defer ts.inflight.Add(-1)
+
+	c := ts.dial(t)
+	c.send("SHUTDOWN")
+	for deadline := time.Now().Add(5 * time.Second); !ts.shutdownStarted(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("SHUTDOWN never started waiting")
+		}
+	}
+
+	// neither a transaction nor the clients queued behind it wait for the shutdown
+	other := ts.dial(t)
+	expect(t, other.do("MULTI"), "OK")
+	expect(t, other.do("SET", "k", "v"), "QUEUED")
+	expect(t, other.do("EXEC"), "[OK]")
+	expect(t, ts.dial(t).do("SHUTDOWN", "ABORT"), "OK")
+	expect(t, c.read(), "-ERR Errors trying to SHUTDOWN. Check logs.")
+}
+
+// shutdownStarted reports whether a shutdown is waiting for in-flight commands.
+func (ts *testServer) shutdownStarted() bool {
+	ts.shutdownMu.Lock()
+	defer ts.shutdownMu.Unlock()
+	return ts.shutdownAbort != nil
+}
+
+func TestProtocolErrorClosesClient(t *testing.T) {
+	ts := startServer(t)
+	for _, input := range []string{
+		"*1\r\n$x\r\n",
+		"*1\r\n:1\r\n",
+		"*2\r\n$3\r\nGETxx",
+		"SET \"k v\r\n",
+	} {
+		c := ts.dial(t)
+		if _, err := c.conn.Write([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+		reply := c.read()
+		if reply.Type != resp.Error || !strings.HasPrefix(*reply.Str, "ERR Protocol error: ") {
+			t.Fatalf("%q: got %s, want a protocol error", input, show(reply))
+		}
+		c.expectClosed()
+	}
+
+	// other clients are not affected
+	expect(t, ts.dial(t).do("PING"), "PONG")
+}
+
+func TestPipelinedReplies(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+	var batch []byte
+	for _, argv := range [][]string{{"SET", "n", "1"}, {"INCR", "n"}, {"GET", "n"}, {"NOSUCHCMD"}} {
+		batch = append(batch, encodeCommand(argv)...)
+	}
+	if _, err := c.conn.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c.read(), "OK")
+	expect(t, c.read(), "2")
+	expect(t, c.read(), "2")
+	if reply := c.read(); reply.Type != resp.Error {
+		t.Fatalf("got %s, want an unknown command error", show(reply))
+	}
+}
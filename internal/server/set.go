@@ -0,0 +1,360 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+/*
+handleSAdd takes the arguments for the SADD command and returns a RESP response.
+SADD key member [member ...]
+It replies with the number of members that were not already in the set.
+*/
+func (s *Server) handleSAdd(c *client, args []string) *resp.Resp {
+	n, err := s.store.SAdd(args[0], args[1:]...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleSRem takes the arguments for the SREM command and returns a RESP response.
+SREM key member [member ...]
+*/
+func (s *Server) handleSRem(c *client, args []string) *resp.Resp {
+	n, err := s.store.SRem(args[0], args[1:]...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleSMembers takes the arguments for the SMEMBERS command and returns a RESP response.
+SMEMBERS key
+*/
+func (s *Server) handleSMembers(c *client, args []string) *resp.Resp {
+	members, err := s.store.SMembers(args[0])
+	if err != nil {
+		return errorReply(err)
+	}
+	return setReply(members)
+}
+
+/*
+handleSIsMember takes the arguments for the SISMEMBER command and returns a RESP response.
+SISMEMBER key member
+*/
+func (s *Server) handleSIsMember(c *client, args []string) *resp.Resp {
+	ok, err := s.store.SIsMember(args[0], args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolReply(ok)
+}
+
+/*
+handleSMIsMember takes the arguments for the SMISMEMBER command and returns a RESP response.
+SMISMEMBER key member [member ...]
+*/
+func (s *Server) handleSMIsMember(c *client, args []string) *resp.Resp {
+	found, err := s.store.SMIsMember(args[0], args[1:]...)
+	if err != nil {
+		return errorReply(err)
+	}
+	reply := &resp.Resp{
+		Type:  resp.Array,
+		Array: make([]*resp.Resp, len(found)),
+	}
+	for i, ok := range found {
+		reply.Array[i] = boolReply(ok)
+	}
+	return reply
+}
+
+/*
+handleSCard takes the arguments for the SCARD command and returns a RESP response.
+SCARD key
+*/
+func (s *Server) handleSCard(c *client, args []string) *resp.Resp {
+	n, err := s.store.SCard(args[0])
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleSPop takes the arguments for the SPOP command and returns a RESP response.
+SPOP key [count]
+Without a count it replies with a single member, or null if the set does not exist. The
+members picked are logged to the AOF as an SREM, so a replay removes the same ones.
+*/
+func (s *Server) handleSPop(c *client, args []string) *resp.Resp {
+	if len(args) > 2 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR value is out of range, must be positive"),
+			}
+		}
+		count = n
+	}
+
+	popped, err := s.store.SPop(args[0], count)
+	if err != nil {
+		return errorReply(err)
+	}
+	if len(popped) > 0 {
+		s.propagate(c, append([]string{"SREM", args[0]}, popped...))
+	}
+
+	if len(args) == 2 {
+		return setReply(popped)
+	}
+	if len(popped) == 0 {
+		return &resp.Resp{
+			Type: resp.BulkString,
+			Str:  nil,
+		}
+	}
+	return &resp.Resp{
+		Type: resp.BulkString,
+		Str:  &popped[0],
+	}
+}
+
+/*
+handleSRandMember takes the arguments for the SRANDMEMBER command and returns a RESP response.
+SRANDMEMBER key [count]
+Without a count it replies with a single member, or null if the set does not exist.
+A negative count may return the same member several times.
+*/
+func (s *Server) handleSRandMember(c *client, args []string) *resp.Resp {
+	if len(args) > 2 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, errResp := parseRandCount(args[1])
+		if errResp != nil {
+			return errResp
+		}
+		count = n
+	}
+
+	members, err := s.store.SRandMember(args[0], count)
+	if err != nil {
+		return errorReply(err)
+	}
+	if len(args) == 2 {
+		return bulkArray(members)
+	}
+	if len(members) == 0 {
+		return &resp.Resp{
+			Type: resp.BulkString,
+			Str:  nil,
+		}
+	}
+	return &resp.Resp{
+		Type: resp.BulkString,
+		Str:  &members[0],
+	}
+}
+
+/*
+handleSMove takes the arguments for the SMOVE command and returns a RESP response.
+SMOVE source destination member
+*/
+func (s *Server) handleSMove(c *client, args []string) *resp.Resp {
+	moved, err := s.store.SMove(args[0], args[1], args[2])
+	if err != nil {
+		return errorReply(err)
+	}
+	return boolReply(moved)
+}
+
+/*
+handleSInter takes the arguments for the SINTER command and returns a RESP response.
+SINTER key [key ...]
+*/
+func (s *Server) handleSInter(c *client, args []string) *resp.Resp {
+	members, err := s.store.SInter(args...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return setReply(members)
+}
+
+/*
+handleSUnion takes the arguments for the SUNION command and returns a RESP response.
+SUNION key [key ...]
+*/
+func (s *Server) handleSUnion(c *client, args []string) *resp.Resp {
+	members, err := s.store.SUnion(args...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return setReply(members)
+}
+
+/*
+handleSDiff takes the arguments for the SDIFF command and returns a RESP response.
+SDIFF key [key ...]
+*/
+func (s *Server) handleSDiff(c *client, args []string) *resp.Resp {
+	members, err := s.store.SDiff(args...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return setReply(members)
+}
+
+/*
+handleSInterStore takes the arguments for the SINTERSTORE command and returns a RESP response.
+SINTERSTORE destination key [key ...]
+The *STORE variants overwrite destination whatever its type, or delete it if the result is
+empty, and reply with the size of the result.
+*/
+func (s *Server) handleSInterStore(c *client, args []string) *resp.Resp {
+	n, err := s.store.SInterStore(args[0], args[1:]...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleSUnionStore takes the arguments for the SUNIONSTORE command and returns a RESP response.
+SUNIONSTORE destination key [key ...]
+*/
+func (s *Server) handleSUnionStore(c *client, args []string) *resp.Resp {
+	n, err := s.store.SUnionStore(args[0], args[1:]...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleSDiffStore takes the arguments for the SDIFFSTORE command and returns a RESP response.
+SDIFFSTORE destination key [key ...]
+*/
+func (s *Server) handleSDiffStore(c *client, args []string) *resp.Resp {
+	n, err := s.store.SDiffStore(args[0], args[1:]...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+/*
+handleSInterCard takes the arguments for the SINTERCARD command and returns a RESP response.
+SINTERCARD numkeys key [key ...] [LIMIT limit]
+A limit of 0, the default, means no limit.
+*/
+func (s *Server) handleSInterCard(c *client, args []string) *resp.Resp {
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil || numkeys <= 0 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR numkeys should be greater than 0"),
+		}
+	}
+	if numkeys > len(args)-1 {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR Number of keys can't be greater than number of args"),
+		}
+	}
+	keys := args[1 : 1+numkeys]
+
+	limit := 0
+	switch opts := args[1+numkeys:]; {
+	case len(opts) == 0:
+	case len(opts) == 2 && strings.ToUpper(opts[0]) == "LIMIT":
+		limit, err = strconv.Atoi(opts[1])
+		if err != nil || limit < 0 {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR LIMIT can't be negative"),
+			}
+		}
+	default:
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+
+	n, err := s.store.SInterCard(limit, keys...)
+	if err != nil {
+		return errorReply(err)
+	}
+	return &resp.Resp{
+		Type: resp.Integer,
+		Int:  int64(n),
+	}
+}
+
+func sintercardKeys(argv []string) []string {
+	return numkeysArgs(argv, 1)
+}
+
+/*
+handleSScan takes the arguments for the SSCAN command and returns a RESP response.
+SSCAN key cursor [MATCH pattern] [COUNT count]
+*/
+func (s *Server) handleSScan(c *client, args []string) *resp.Resp {
+	cursor, pattern, count, _, errResp := parseScan(args[1:], "")
+	if errResp != nil {
+		return errResp
+	}
+
+	members, next, err := s.store.SScan(args[0], cursor, pattern, count)
+	if err != nil {
+		return errorReply(err)
+	}
+	return scanReply(next, members)
+}
+
+// setReply replies with members as a set, which RESP2 clients get as a plain array.
+func setReply(members []string) *resp.Resp {
+	reply := bulkArray(members)
+	reply.Type = resp.Set
+	return reply
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSRandMemberCount(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+	expect(t, c.do("SADD", "s", "a", "b"), "2")
+
+	expect(t, c.do("SRANDMEMBER", "s", "-1000000000000"), "-ERR value is out of range")
+	expect(t, c.do("SRANDMEMBER", "s", "x"), "-ERR value is not an integer or out of range")
+	if reply := c.do("SRANDMEMBER", "s", "-5"); len(reply.Array) != 5 {
+		t.Fatalf("SRANDMEMBER s -5 = %s, want 5 members", show(reply))
+	}
+	if reply := c.do("SRANDMEMBER", "s", "1000000000000"); len(reply.Array) != 2 {
+		t.Fatalf("SRANDMEMBER s 1000000000000 = %s, want both members", show(reply))
+	}
+	expect(t, c.do("SRANDMEMBER", "missing", "-5"), "[]")
+}
+
+func TestSScanMatch(t *testing.T) {
+	ts := startServer(t)
+	c := ts.dial(t)
+	args := []string{"SADD", "s"}
+	for i := 0; i < 300; i++ {
+		args = append(args, "m"+strconv.Itoa(i))
+	}
+	expect(t, c.do(args...), "300")
+
+	matched := map[string]bool{}
+	cursor := "0"
+	for {
+		reply := c.do("SSCAN", "s", cursor, "MATCH", "m2?", "COUNT", "20")
+		cursor = *reply.Array[0].Str
+		for _, m := range reply.Array[1].Array {
+			matched[*m.Str] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(matched) != 10 || !matched["m20"] || !matched["m29"] {
+		t.Fatalf("matched %v, want m20 to m29", matched)
+	}
+
+	long := strings.Repeat("a", 5000)
+	expect(t, c.do("SADD", "g", long), "1")
+	expect(t, c.do("SSCAN", "g", "0", "MATCH", strings.Repeat("*a", 20)+"*b"), "[0 []]")
+}
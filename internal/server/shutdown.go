@@ -0,0 +1,182 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+var (
+	errShutdownAborted    = errors.New("shutdown was aborted")
+	errShutdownInProgress = errors.New("a shutdown is already in progress")
+)
+
+// ShutdownOptions mirror the flags of the SHUTDOWN command.
+type ShutdownOptions struct {
+	Now   bool // don't wait for in-flight commands to finish
+	Force bool // shut down even if the AOF could not be flushed
+}
+
+/*
+Shutdown stops the server gracefully: it waits up to shutdown-timeout seconds for in-flight
+commands to finish, flushes the AOF to disk and then closes the server.
+Unless Force is set a failure to flush the AOF aborts the shutdown and the server keeps running.
+*/
+func (s *Server) Shutdown(opts ShutdownOptions) error {
+	return s.shutdown(nil, opts)
+}
+
+// shutdown implements Shutdown, caller is the client that sent SHUTDOWN or nil for a signal.
+func (s *Server) shutdown(caller *client, opts ShutdownOptions) error {
+	s.shutdownMu.Lock()
+	if s.shutdownAbort != nil {
+		s.shutdownMu.Unlock()
+		return errShutdownInProgress
+	}
+	abort := make(chan struct{})
+	s.shutdownAbort = abort
+	s.shutdownMu.Unlock()
+
+	defer func() {
+		s.shutdownMu.Lock()
+		s.shutdownAbort = nil
+		s.shutdownMu.Unlock()
+	}()
+
+	if !opts.Now {
+		// the caller's own SHUTDOWN is one of the in-flight commands
+		var self int64
+		if caller != nil {
+			self = 1
+		}
+		deadline := time.Now().Add(time.Duration(s.cfg.Int("shutdown-timeout")) * time.Second)
+		for s.inflight.Load() > self && time.Now().Before(deadline) {
+			select {
+			case <-abort:
+				return errShutdownAborted
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	if s.aof != nil {
+		if err := s.aof.Sync(); err != nil {
+			if !opts.Force {
+				return err
+			}
+			log.Printf("Error flushing the AOF, shutting down anyway: %v", err)
+		}
+	}
+
+	log.Printf("GoRedis is now ready to exit, bye bye...")
+	if caller != nil {
+		// Close waits for every client goroutine, including the caller's, to exit
+		go s.Close()
+		return nil
+	}
+	return s.Close()
+}
+
+// abortShutdown cancels a shutdown that is still waiting for in-flight commands.
+func (s *Server) abortShutdown() bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+
+	if s.shutdownAbort == nil {
+		return false
+	}
+	close(s.shutdownAbort)
+	s.shutdownAbort = nil
+	return true
+}
+
+/*
+Close stops the server: it stops accepting connections, drops every client once the
+command it is running has finished, closes the AOF and stops the store's background work.
+It is safe to call more than once and returns the error of the first call.
+*/
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		s.connMu.Lock()
+		s.closing.Store(true)
+		for _, ln := range s.listeners {
+			ln.Close()
+		}
+		// closing the connections unblocks the reads, each client goroutine then exits
+		for _, c := range s.clients {
+			c.conn.Close()
+		}
+		s.connMu.Unlock()
+
+		s.clientsWG.Wait()
+
+		if s.aof != nil {
+			s.closeErr = s.aof.Close()
+		}
+		s.store.Close()
+		close(s.closed)
+	})
+	<-s.closed
+	return s.closeErr
+}
+
+/*
+handleShutdown takes the arguments for the SHUTDOWN command and returns a RESP response.
+SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT]
+There are no RDB snapshots, so SAVE and NOSAVE are accepted but have no effect; the AOF is
+always flushed. On success no reply is sent, the connection is closed along with the server.
+*/
+func (s *Server) handleShutdown(c *client, args []string) *resp.Resp {
+	var opts ShutdownOptions
+	var save, nosave, abort bool
+	for _, arg := range args {
+		switch strings.ToUpper(arg) {
+		case "SAVE":
+			save = true
+		case "NOSAVE":
+			nosave = true
+		case "NOW":
+			opts.Now = true
+		case "FORCE":
+			opts.Force = true
+		case "ABORT":
+			abort = true
+		default:
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR syntax error"),
+			}
+		}
+	}
+	if (save && nosave) || (abort && len(args) > 1) {
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR syntax error"),
+		}
+	}
+
+	if abort {
+		if !s.abortShutdown() {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR No shutdown in progress."),
+			}
+		}
+		return &resp.Resp{
+			Type: resp.SimpleString,
+			Str:  strPtr("OK"),
+		}
+	}
+
+	if err := s.shutdown(c, opts); err != nil {
+		log.Printf("SHUTDOWN failed: %v", err)
+		return &resp.Resp{
+			Type: resp.Error,
+			Str:  strPtr("ERR Errors trying to SHUTDOWN. Check logs."),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,516 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+type commandFlag int
+
+const (
+	flagWrite    commandFlag = 1 << iota // may modify the dataset, logged to the AOF
+	flagReadonly                         // only reads the dataset
+	flagPubsub                           // part of the pub/sub family
+	flagAdmin                            // administrative command
+	flagFast                             // runs in O(1) or O(log N)
+	flagNoAuth                           // may run before the client has authenticated
+	flagBlocking                         // may block the client until a key gets data
+)
+
+var flagNames = []struct {
+	flag commandFlag
+	name string
+}{
+	{flagWrite, "write"},
+	{flagReadonly, "readonly"},
+	{flagPubsub, "pubsub"},
+	{flagAdmin, "admin"},
+	{flagFast, "fast"},
+	{flagNoAuth, "no_auth"},
+	{flagBlocking, "blocking"},
+}
+
+/*
+command describes a single command the server understands.
+arity counts the command name itself; a negative arity means at least -arity arguments.
+firstKey, lastKey and step give the positions of the key arguments in argv, the same way
+Redis does: a lastKey of -1 means the last argument and a firstKey of 0 means no keys.
+Commands whose keys move around, like BLMPOP with its numkeys argument, find them with getKeys instead.
+firstChannel and lastChannel give the pub/sub channel arguments the same way, for ACL checks.
+Container commands take a subcommand, which ACL rules can allow or deny on its own.
+*/
+type command struct {
+	name         string
+	handler      func(s *Server, c *client, args []string) *resp.Resp
+	arity        int
+	flags        commandFlag
+	firstKey     int
+	lastKey      int
+	step         int
+	getKeys      func(argv []string) []string
+	firstChannel int
+	lastChannel  int
+	container    bool
+	categories   []string // ACL categories on top of the ones implied by flags and group
+
+	// adminSubcommands are subcommands the ACL puts in @admin and @dangerous, for containers
+	// like ACL whose other subcommands anybody may run
+	adminSubcommands []string
+
+	// propagatesItself is set for write commands whose handler calls propagate with what it
+	// actually did, such as the pop a blocking command ended up doing, instead of logging argv
+	propagatesItself bool
+
+	// documentation returned by COMMAND DOCS
+	summary string
+	since   string
+	group   string
+}
+
+// commandTable holds every command keyed by its lower case name.
+var commandTable = map[string]*command{}
+
+func init() {
+	for _, cmd := range []*command{
+		{name: "ping", handler: (*Server).handlePing, arity: -1, flags: flagFast,
+			summary: "Returns the server's liveliness response.", since: "1.0.0", group: "connection"},
+		{name: "echo", handler: (*Server).handleEcho, arity: 2, flags: flagFast,
+			summary: "Returns the given string.", since: "1.0.0", group: "connection"},
+		{name: "hello", handler: (*Server).handleHello, arity: -1, flags: flagFast | flagNoAuth,
+			summary: "Handshakes with the Redis server.", since: "6.0.0", group: "connection"},
+		{name: "auth", handler: (*Server).handleAuth, arity: -2, flags: flagFast | flagNoAuth,
+			summary: "Authenticates the connection.", since: "1.0.0", group: "connection"},
+		{name: "client", handler: (*Server).handleClient, arity: -2, container: true, adminSubcommands: []string{"kill", "list"},
+			summary: "A container for client connection commands.", since: "2.4.0", group: "connection"},
+		{name: "command", handler: (*Server).handleCommand, arity: -1, container: true,
+			summary: "Returns detailed information about all commands.", since: "2.8.13", group: "server"},
+		{name: "config", handler: (*Server).handleConfig, arity: -2, flags: flagAdmin, container: true,
+			summary: "A container for server configuration commands.", since: "2.0.0", group: "server"},
+		{name: "acl", handler: (*Server).handleACL, arity: -2, container: true, adminSubcommands: []string{"setuser", "getuser", "deluser", "list", "users", "log", "load", "save"},
+			summary: "A container for Access List Control commands.", since: "6.0.0", group: "server"},
+		{name: "info", handler: (*Server).handleInfo, arity: -1,
+			summary: "Returns information and statistics about the server.", since: "1.0.0", group: "server"},
+		{name: "shutdown", handler: (*Server).handleShutdown, arity: -1, flags: flagAdmin,
+			summary: "Synchronously saves the database(s) to disk and shuts down the Redis server.", since: "1.0.0", group: "server"},
+
+		{name: "multi", handler: (*Server).handleMulti, arity: 1, flags: flagFast,
+			summary: "Starts a transaction.", since: "1.2.0", group: "transactions"},
+		{name: "exec", handler: (*Server).handleExec, arity: 1,
+			summary: "Executes all commands in a transaction.", since: "1.2.0", group: "transactions"},
+		{name: "discard", handler: (*Server).handleDiscard, arity: 1, flags: flagFast,
+			summary: "Discards a transaction.", since: "2.0.0", group: "transactions"},
+		{name: "watch", handler: (*Server).handleWatch, arity: -2, flags: flagFast, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Monitors changes to keys to determine the execution of a transaction.", since: "2.2.0", group: "transactions"},
+		{name: "unwatch", handler: (*Server).handleUnwatch, arity: 1, flags: flagFast,
+			summary: "Forgets about watched keys of a transaction.", since: "2.2.0", group: "transactions"},
+
+		{name: "set", handler: (*Server).handleSet, arity: -3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", since: "1.0.0", group: "string"},
+		{name: "get", handler: (*Server).handleGet, arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the string value of a key.", since: "1.0.0", group: "string"},
+		{name: "incr", handler: (*Server).handleIncr, arity: 2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", since: "1.0.0", group: "string"},
+
+		{name: "del", handler: (*Server).handleDel, arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Deletes one or more keys.", since: "1.0.0", group: "generic"},
+		{name: "object", handler: (*Server).handleObject, arity: -2, flags: flagReadonly, firstKey: 2, lastKey: 2, step: 1, container: true,
+			summary: "A container for object introspection commands.", since: "2.2.3", group: "generic"},
+		{name: "ttl", handler: (*Server).handleTTL, arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the expiration time in seconds of a key.", since: "1.0.0", group: "generic"},
+
+		{name: "lpush", handler: (*Server).handleLPush, arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, propagatesItself: true,
+			summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.", since: "1.0.0", group: "list"},
+		{name: "rpush", handler: (*Server).handleRPush, arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, propagatesItself: true,
+			summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.", since: "1.0.0", group: "list"},
+		{name: "lpop", handler: (*Server).handleLPop, arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.", since: "1.0.0", group: "list"},
+		{name: "rpop", handler: (*Server).handleRPop, arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns and removes the last elements of a list. Deletes the list if the last element was popped.", since: "1.0.0", group: "list"},
+		{name: "lrange", handler: (*Server).handleLRange, arity: 4, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns a range of elements from a list.", since: "1.0.0", group: "list"},
+		{name: "lpushx", handler: (*Server).handleLPushX, arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, propagatesItself: true,
+			summary: "Prepends one or more elements to a list only when the list exists.", since: "2.2.0", group: "list"},
+		{name: "rpushx", handler: (*Server).handleRPushX, arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, propagatesItself: true,
+			summary: "Appends an element to a list only when the list exists.", since: "2.2.0", group: "list"},
+		{name: "llen", handler: (*Server).handleLLen, arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the length of a list.", since: "1.0.0", group: "list"},
+		{name: "lindex", handler: (*Server).handleLIndex, arity: 3, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns an element from a list by its index.", since: "1.0.0", group: "list"},
+		{name: "lset", handler: (*Server).handleLSet, arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Sets the value of an element in a list by its index.", since: "1.0.0", group: "list"},
+		{name: "linsert", handler: (*Server).handleLInsert, arity: 5, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Inserts an element before or after another element in a list.", since: "2.2.0", group: "list"},
+		{name: "lrem", handler: (*Server).handleLRem, arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Removes elements from a list. Deletes the list if the last element was removed.", since: "1.0.0", group: "list"},
+		{name: "ltrim", handler: (*Server).handleLTrim, arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Removes elements from both ends a list. Deletes the list if all elements were trimmed.", since: "1.0.0", group: "list"},
+		{name: "lpos", handler: (*Server).handleLPos, arity: -3, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the index of matching elements in a list.", since: "6.0.6", group: "list"},
+		{name: "lmove", handler: (*Server).handleLMove, arity: 5, flags: flagWrite, firstKey: 1, lastKey: 2, step: 1, propagatesItself: true,
+			summary: "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.", since: "6.2.0", group: "list"},
+		{name: "rpoplpush", handler: (*Server).handleRPopLPush, arity: 3, flags: flagWrite, firstKey: 1, lastKey: 2, step: 1, propagatesItself: true,
+			summary: "Returns the last element of a list after removing and pushing it to another list. Deletes the list if the last element was popped.", since: "1.2.0", group: "list"},
+		{name: "lmpop", handler: (*Server).handleLMPop, arity: -4, flags: flagWrite, getKeys: lmpopKeys,
+			summary: "Returns multiple elements from a list after removing them. Deletes the list if the last element was popped.", since: "7.0.0", group: "list"},
+		{name: "blpop", handler: (*Server).handleBLPop, arity: -3, flags: flagWrite | flagBlocking, firstKey: 1, lastKey: -2, step: 1, propagatesItself: true,
+			summary: "Removes and returns the first element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", since: "2.0.0", group: "list"},
+		{name: "brpop", handler: (*Server).handleBRPop, arity: -3, flags: flagWrite | flagBlocking, firstKey: 1, lastKey: -2, step: 1, propagatesItself: true,
+			summary: "Removes and returns the last element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", since: "2.0.0", group: "list"},
+		{name: "blmove", handler: (*Server).handleBLMove, arity: 6, flags: flagWrite | flagBlocking, firstKey: 1, lastKey: 2, step: 1, propagatesItself: true,
+			summary: "Pops an element from a list, pushes it to another list and returns it. Blocks until an element is available otherwise. Deletes the list if the last element was moved.", since: "6.2.0", group: "list"},
+		{name: "blmpop", handler: (*Server).handleBLMPop, arity: -5, flags: flagWrite | flagBlocking, getKeys: blmpopKeys, propagatesItself: true,
+			summary: "Pops the first element from one of multiple lists. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", since: "7.0.0", group: "list"},
+
+		{name: "hset", handler: (*Server).handleHSet, arity: -4, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Creates or modifies the value of a field in a hash.", since: "2.0.0", group: "hash"},
+		{name: "hsetnx", handler: (*Server).handleHSetNX, arity: 4, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Sets the value of a field in a hash only when the field doesn't exist.", since: "2.0.0", group: "hash"},
+		{name: "hget", handler: (*Server).handleHGet, arity: 3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the value of a field in a hash.", since: "2.0.0", group: "hash"},
+		{name: "hmget", handler: (*Server).handleHMGet, arity: -3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the values of all fields in a hash.", since: "2.0.0", group: "hash"},
+		{name: "hgetall", handler: (*Server).handleHGetAll, arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns all fields and values in a hash.", since: "2.0.0", group: "hash"},
+		{name: "hkeys", handler: (*Server).handleHKeys, arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns all fields in a hash.", since: "2.0.0", group: "hash"},
+		{name: "hvals", handler: (*Server).handleHVals, arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns all values in a hash.", since: "2.0.0", group: "hash"},
+		{name: "hdel", handler: (*Server).handleHDel, arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.", since: "2.0.0", group: "hash"},
+		{name: "hexists", handler: (*Server).handleHExists, arity: 3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Determines whether a field exists in a hash.", since: "2.0.0", group: "hash"},
+		{name: "hlen", handler: (*Server).handleHLen, arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the number of fields in a hash.", since: "2.0.0", group: "hash"},
+		{name: "hstrlen", handler: (*Server).handleHStrLen, arity: 3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the length of the value of a field.", since: "3.2.0", group: "hash"},
+		{name: "hincrby", handler: (*Server).handleHIncrBy, arity: 4, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.", since: "2.0.0", group: "hash"},
+		{name: "hincrbyfloat", handler: (*Server).handleHIncrByFloat, arity: 4, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, propagatesItself: true,
+			summary: "Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.", since: "2.6.0", group: "hash"},
+		{name: "hrandfield", handler: (*Server).handleHRandField, arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns one or more random fields from a hash.", since: "6.2.0", group: "hash"},
+		{name: "hscan", handler: (*Server).handleHScan, arity: -3, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Iterates over fields and values of a hash.", since: "2.8.0", group: "hash"},
+		{name: "hexpire", handler: (*Server).handleHExpire, arity: -6, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, propagatesItself: true,
+			summary: "Set expiry for hash field using relative time to expire (seconds)", since: "7.4.0", group: "hash"},
+		{name: "hpexpire", handler: (*Server).handleHPExpire, arity: -6, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, propagatesItself: true,
+			summary: "Set expiry for hash field using relative time to expire (milliseconds)", since: "7.4.0", group: "hash"},
+		{name: "hexpireat", handler: (*Server).handleHExpireAt, arity: -6, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, propagatesItself: true,
+			summary: "Set expiry for hash field using an absolute Unix timestamp (seconds)", since: "7.4.0", group: "hash"},
+		{name: "hpexpireat", handler: (*Server).handleHPExpireAt, arity: -6, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, propagatesItself: true,
+			summary: "Set expiry for hash field using an absolute Unix timestamp (milliseconds)", since: "7.4.0", group: "hash"},
+		{name: "hpersist", handler: (*Server).handleHPersist, arity: -5, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Removes the expiration time for each specified field", since: "7.4.0", group: "hash"},
+		{name: "httl", handler: (*Server).handleHTTL, arity: -5, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the TTL in seconds of a hash field.", since: "7.4.0", group: "hash"},
+		{name: "hpttl", handler: (*Server).handleHPTTL, arity: -5, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the TTL in milliseconds of a hash field.", since: "7.4.0", group: "hash"},
+		{name: "hexpiretime", handler: (*Server).handleHExpireTime, arity: -5, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the expiration time of a hash field as a Unix timestamp, in seconds.", since: "7.4.0", group: "hash"},
+		{name: "hpexpiretime", handler: (*Server).handleHPExpireTime, arity: -5, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the expiration time of a hash field as a Unix timestamp, in msec.", since: "7.4.0", group: "hash"},
+
+		{name: "sadd", handler: (*Server).handleSAdd, arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Adds one or more members to a set. Creates the key if it doesn't exist.", since: "1.0.0", group: "set"},
+		{name: "srem", handler: (*Server).handleSRem, arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Removes one or more members from a set. Deletes the set if the last member was removed.", since: "1.0.0", group: "set"},
+		{name: "smembers", handler: (*Server).handleSMembers, arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns all members of a set.", since: "1.0.0", group: "set"},
+		{name: "sismember", handler: (*Server).handleSIsMember, arity: 3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Determines whether a member belongs to a set.", since: "1.0.0", group: "set"},
+		{name: "smismember", handler: (*Server).handleSMIsMember, arity: -3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Determines whether multiple members belong to a set.", since: "6.2.0", group: "set"},
+		{name: "scard", handler: (*Server).handleSCard, arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the number of members in a set.", since: "1.0.0", group: "set"},
+		{name: "spop", handler: (*Server).handleSPop, arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, propagatesItself: true,
+			summary: "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.", since: "1.0.0", group: "set"},
+		{name: "srandmember", handler: (*Server).handleSRandMember, arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Get one or multiple random members from a set", since: "1.0.0", group: "set"},
+		{name: "smove", handler: (*Server).handleSMove, arity: 4, flags: flagWrite | flagFast, firstKey: 1, lastKey: 2, step: 1,
+			summary: "Moves a member from one set to another.", since: "1.0.0", group: "set"},
+		{name: "sinter", handler: (*Server).handleSInter, arity: -2, flags: flagReadonly, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Returns the intersect of multiple sets.", since: "1.0.0", group: "set"},
+		{name: "sinterstore", handler: (*Server).handleSInterStore, arity: -3, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Stores the intersect of multiple sets in a key.", since: "1.0.0", group: "set"},
+		{name: "sunion", handler: (*Server).handleSUnion, arity: -2, flags: flagReadonly, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Returns the union of multiple sets.", since: "1.0.0", group: "set"},
+		{name: "sunionstore", handler: (*Server).handleSUnionStore, arity: -3, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Stores the union of multiple sets in a key.", since: "1.0.0", group: "set"},
+		{name: "sdiff", handler: (*Server).handleSDiff, arity: -2, flags: flagReadonly, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Returns the difference of multiple sets.", since: "1.0.0", group: "set"},
+		{name: "sdiffstore", handler: (*Server).handleSDiffStore, arity: -3, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Stores the difference of multiple sets in a key.", since: "1.0.0", group: "set"},
+		{name: "sintercard", handler: (*Server).handleSInterCard, arity: -3, flags: flagReadonly, getKeys: sintercardKeys,
+			summary: "Returns the number of members of the intersect of multiple sets.", since: "7.0.0", group: "set"},
+		{name: "sscan", handler: (*Server).handleSScan, arity: -3, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Iterates over members of a set.", since: "2.8.0", group: "set"},
+
+		{name: "subscribe", handler: (*Server).handleSubscribe, arity: -2, flags: flagPubsub, firstChannel: 1, lastChannel: -1,
+			summary: "Listens for messages published to channels.", since: "2.0.0", group: "pubsub"},
+		{name: "unsubscribe", handler: (*Server).handleUnsubscribe, arity: -1, flags: flagPubsub,
+			summary: "Stops listening to messages posted to channels.", since: "2.0.0", group: "pubsub"},
+		{name: "publish", handler: (*Server).handlePublish, arity: 3, flags: flagPubsub | flagFast, firstChannel: 1, lastChannel: 1,
+			summary: "Posts a message to a channel.", since: "2.0.0", group: "pubsub"},
+	} {
+		commandTable[cmd.name] = cmd
+	}
+}
+
+// lookupCommand finds a command by name, ignoring case.
+func lookupCommand(name string) (*command, bool) {
+	cmd, ok := commandTable[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// arityOK reports whether argc, which includes the command name, satisfies the command's arity.
+func (cmd *command) arityOK(argc int) bool {
+	if cmd.arity >= 0 {
+		return argc == cmd.arity
+	}
+	return argc >= -cmd.arity
+}
+
+// keys returns the key arguments of argv according to the command's key positions.
+func (cmd *command) keys(argv []string) []string {
+	if cmd.getKeys != nil {
+		return cmd.getKeys(argv)
+	}
+	return argsAt(argv, cmd.firstKey, cmd.lastKey, cmd.step)
+}
+
+// channels returns the pub/sub channel arguments of argv according to the command's channel positions.
+func (cmd *command) channels(argv []string) []string {
+	return argsAt(argv, cmd.firstChannel, cmd.lastChannel, 1)
+}
+
+func argsAt(argv []string, first, last, step int) []string {
+	if first == 0 {
+		return nil
+	}
+	if last < 0 {
+		last = len(argv) + last
+	}
+	var out []string
+	for i := first; i <= last && i < len(argv); i += step {
+		out = append(out, argv[i])
+	}
+	return out
+}
+
+// aclCategories returns the ACL categories of the command, derived from its flags and group.
+func (cmd *command) aclCategories() []string {
+	var cats []string
+	if cmd.flags&flagWrite != 0 {
+		cats = append(cats, "write")
+	}
+	if cmd.flags&flagReadonly != 0 {
+		cats = append(cats, "read")
+	}
+	switch cmd.group {
+	case "generic":
+		cats = append(cats, "keyspace")
+	case "string", "list", "hash", "set", "sortedset", "connection":
+		cats = append(cats, cmd.group)
+	case "transactions":
+		cats = append(cats, "transaction")
+	}
+	if cmd.flags&flagPubsub != 0 {
+		cats = append(cats, "pubsub")
+	}
+	if cmd.flags&flagAdmin != 0 {
+		cats = append(cats, "admin", "dangerous")
+	}
+	if cmd.flags&flagBlocking != 0 {
+		cats = append(cats, "blocking")
+	}
+	if cmd.flags&flagFast != 0 {
+		cats = append(cats, "fast")
+	} else {
+		cats = append(cats, "slow")
+	}
+	return append(cats, cmd.categories...)
+}
+
+// subcommandCategories returns the ACL categories subcommands have on top of the container's.
+func (cmd *command) subcommandCategories() map[string][]string {
+	subs := make(map[string][]string, len(cmd.adminSubcommands))
+	for _, sub := range cmd.adminSubcommands {
+		subs[sub] = []string{"admin", "dangerous"}
+	}
+	return subs
+}
+
+// info returns the reply COMMAND and COMMAND INFO give for the command.
+func (cmd *command) info() *resp.Resp {
+	flags := []*resp.Resp{}
+	for _, f := range flagNames {
+		if cmd.flags&f.flag != 0 {
+			flags = append(flags, &resp.Resp{Type: resp.SimpleString, Str: strPtr(f.name)})
+		}
+	}
+
+	categories := []*resp.Resp{}
+	for _, cat := range cmd.aclCategories() {
+		categories = append(categories, &resp.Resp{Type: resp.SimpleString, Str: strPtr("@" + cat)})
+	}
+
+	return &resp.Resp{
+		Type: resp.Array,
+		Array: []*resp.Resp{
+			{Type: resp.BulkString, Str: strPtr(cmd.name)},
+			{Type: resp.Integer, Int: int64(cmd.arity)},
+			{Type: resp.Set, Array: flags},
+			{Type: resp.Integer, Int: int64(cmd.firstKey)},
+			{Type: resp.Integer, Int: int64(cmd.lastKey)},
+			{Type: resp.Integer, Int: int64(cmd.step)},
+			{Type: resp.Set, Array: categories},
+			{Type: resp.Set, Array: []*resp.Resp{}},   // tips
+			{Type: resp.Array, Array: []*resp.Resp{}}, // key specifications
+			{Type: resp.Array, Array: []*resp.Resp{}}, // subcommands
+		},
+	}
+}
+
+// docs returns the documentation map COMMAND DOCS gives for the command.
+func (cmd *command) docs() *resp.Resp {
+	return &resp.Resp{
+		Type: resp.Map,
+		Array: []*resp.Resp{
+			{Type: resp.BulkString, Str: strPtr("summary")},
+			{Type: resp.BulkString, Str: strPtr(cmd.summary)},
+			{Type: resp.BulkString, Str: strPtr("since")},
+			{Type: resp.BulkString, Str: strPtr(cmd.since)},
+			{Type: resp.BulkString, Str: strPtr("group")},
+			{Type: resp.BulkString, Str: strPtr(cmd.group)},
+		},
+	}
+}
+
+// sortedCommands returns every command in the table ordered by name.
+func sortedCommands() []*command {
+	cmds := make([]*command, 0, len(commandTable))
+	for _, cmd := range commandTable {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].name < cmds[j].name })
+	return cmds
+}
+
+/*
+handleCommand takes the arguments for the COMMAND command and returns a RESP response.
+COMMAND on its own describes every command, the COUNT, INFO, DOCS and GETKEYS subcommands
+describe the table or a subset of it.
+*/
+func (s *Server) handleCommand(c *client, args []string) *resp.Resp {
+	if len(args) == 0 {
+		infos := []*resp.Resp{}
+		for _, cmd := range sortedCommands() {
+			infos = append(infos, cmd.info())
+		}
+		return &resp.Resp{
+			Type:  resp.Array,
+			Array: infos,
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "COUNT":
+		if len(args) != 1 {
+			break
+		}
+		return &resp.Resp{
+			Type: resp.Integer,
+			Int:  int64(len(commandTable)),
+		}
+
+	case "INFO":
+		names := args[1:]
+		if len(names) == 0 {
+			for _, cmd := range sortedCommands() {
+				names = append(names, cmd.name)
+			}
+		}
+		infos := make([]*resp.Resp, 0, len(names))
+		for _, name := range names {
+			cmd, ok := lookupCommand(name)
+			if !ok {
+				infos = append(infos, &resp.Resp{Type: resp.Array, Array: nil})
+				continue
+			}
+			infos = append(infos, cmd.info())
+		}
+		return &resp.Resp{
+			Type:  resp.Array,
+			Array: infos,
+		}
+
+	case "DOCS":
+		names := args[1:]
+		if len(names) == 0 {
+			for _, cmd := range sortedCommands() {
+				names = append(names, cmd.name)
+			}
+		}
+		docs := []*resp.Resp{}
+		for _, name := range names {
+			cmd, ok := lookupCommand(name)
+			if !ok {
+				// unknown commands are left out of the reply
+				continue
+			}
+			docs = append(docs,
+				&resp.Resp{Type: resp.BulkString, Str: strPtr(cmd.name)},
+				cmd.docs(),
+			)
+		}
+		return &resp.Resp{
+			Type:  resp.Map,
+			Array: docs,
+		}
+
+	case "GETKEYS":
+		if len(args) < 2 {
+			break
+		}
+		argv := args[1:]
+		cmd, ok := lookupCommand(argv[0])
+		if !ok {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR Invalid command specified"),
+			}
+		}
+		if !cmd.arityOK(len(argv)) {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR Invalid number of arguments specified for command"),
+			}
+		}
+		keys := cmd.keys(argv)
+		if len(keys) == 0 {
+			return &resp.Resp{
+				Type: resp.Error,
+				Str:  strPtr("ERR The command has no key arguments"),
+			}
+		}
+		respArr := make([]*resp.Resp, len(keys))
+		for i, k := range keys {
+			key := k
+			respArr[i] = &resp.Resp{
+				Type: resp.BulkString,
+				Str:  &key,
+			}
+		}
+		return &resp.Resp{
+			Type:  resp.Array,
+			Array: respArr,
+		}
+	}
+
+	return &resp.Resp{
+		Type: resp.Error,
+		Str:  strPtr("ERR unknown subcommand or wrong number of arguments for '" + args[0] + "'"),
+	}
+}
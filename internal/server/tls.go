@@ -0,0 +1,60 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tlsVersions maps the tls-min-version config values to crypto/tls versions.
+var tlsVersions = map[string]uint16{
+	"tlsv1.2": tls.VersionTLS12,
+	"tlsv1.3": tls.VersionTLS13,
+}
+
+/*
+tlsConfig builds the configuration of the TLS listener from the tls-* settings.
+Client certificates are verified against tls-ca-cert-file: always with tls-auth-clients yes,
+only when the client sends one with optional, and never with no.
+*/
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	certFile, keyFile := s.cfg.String("tls-cert-file"), s.cfg.String("tls-key-file")
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tlsVersions[s.cfg.String("tls-min-version")],
+	}
+
+	switch s.cfg.String("tls-auth-clients") {
+	case "yes":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		cfg.ClientAuth = tls.NoClientCert
+	}
+
+	if caFile := s.cfg.String("tls-ca-cert-file"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.ClientCAs = pool
+	} else if cfg.ClientAuth != tls.NoClientCert {
+		return nil, errors.New("tls-ca-cert-file is required to authenticate clients, or set tls-auth-clients no")
+	}
+	return cfg, nil
+}
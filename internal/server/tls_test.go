@@ -0,0 +1,289 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blvckbill/redis-from-scratch/internal/config"
+	resp "github.com/blvckbill/redis-from-scratch/internal/protocol"
+)
+
+// testCA is a self-signed certificate authority generated for a test.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+	file string // the CA certificate in PEM
+}
+
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	t.Helper()
+	key := newKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{cert: cert, key: key, pool: x509.NewCertPool(), file: filepath.Join(dir, name+".crt")}
+	ca.pool.AddCert(cert)
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue signs a certificate for a server on 127.0.0.1 or, with client set, for a client.
+func (ca *testCA) issue(t *testing.T, name string, client bool) tls.Certificate {
+	t.Helper()
+	key := newKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if client {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		tmpl.IPAddresses = nil
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tlsEnv holds the certificates of a TLS test: a CA trusted by the server and one that is not.
+type tlsEnv struct {
+	ca, rogue         *testCA
+	certFile, keyFile string
+	client, untrusted tls.Certificate
+}
+
+func newTLSEnv(t *testing.T) *tlsEnv {
+	t.Helper()
+	dir := t.TempDir()
+	env := &tlsEnv{ca: newTestCA(t, dir, "ca"), rogue: newTestCA(t, dir, "rogue")}
+
+	server := env.ca.issue(t, "server", false)
+	env.certFile, env.keyFile = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writePEM(t, env.certFile, "CERTIFICATE", server.Certificate[0])
+	keyDER, err := x509.MarshalECPrivateKey(server.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, env.keyFile, "EC PRIVATE KEY", keyDER)
+
+	env.client = env.ca.issue(t, "client", true)
+	env.untrusted = env.rogue.issue(t, "intruder", true)
+	return env
+}
+
+// start starts a server listening for TLS on a free port and returns its address.
+func (env *tlsEnv) start(t *testing.T, args ...string) string {
+	t.Helper()
+	port := freePort(t)
+	startServer(t, append([]string{"--tls-port", port,
+		"--tls-cert-file", env.certFile, "--tls-key-file", env.keyFile,
+		"--tls-ca-cert-file", env.ca.file}, args...)...)
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+/*
+clientConfig returns a client configuration trusting the test CA and presenting cert, if any.
+The certificate is sent even when the server does not list its issuer as acceptable, which
+crypto/tls would otherwise skip, so that the server is the one that rejects it.
+*/
+func (env *tlsEnv) clientConfig(cert ...tls.Certificate) *tls.Config {
+	cfg := &tls.Config{RootCAs: env.ca.pool}
+	if len(cert) > 0 {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &cert[0], nil
+		}
+	}
+	return cfg
+}
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+}
+
+/*
+tlsPing connects over TLS and sends a PING, returning the reply or the error that stopped it.
+With TLS 1.3 a rejected client certificate only shows up when the client reads, so
+handshake failures are reported by either step.
+*/
+func tlsPing(addr string, cfg *tls.Config) (string, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, cfg)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(encodeCommand([]string{"PING"})); err != nil {
+		return "", err
+	}
+	reply, err := resp.NewReader(conn).ReadValue()
+	if err != nil {
+		return "", err
+	}
+	return show(reply), nil
+}
+
+func TestTLSHandshake(t *testing.T) {
+	env := newTLSEnv(t)
+	addr := env.start(t, "--tls-auth-clients", "no")
+
+	conn, err := tls.Dial("tcp", addr, env.clientConfig())
+	if err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	if !state.HandshakeComplete || state.PeerCertificates[0].Subject.CommonName != "server" {
+		t.Fatalf("unexpected connection state %+v", state)
+	}
+
+	c := &testConn{t: t, conn: conn, rd: resp.NewReader(conn)}
+	expect(t, c.do("SET", "k", "v"), "OK")
+	expect(t, c.do("GET", "k"), "v")
+
+	// a client that does not trust the CA refuses the server
+	if _, err := tlsPing(addr, &tls.Config{}); err == nil {
+		t.Fatal("a client without the CA accepted the server certificate")
+	}
+}
+
+func TestTLSAuthClients(t *testing.T) {
+	env := newTLSEnv(t)
+	tests := []struct {
+		mode                     string
+		noCert, valid, untrusted bool // whether each kind of client gets in
+	}{
+		{"yes", false, true, false},
+		{"optional", true, true, false},
+		// with no the server does not ask for a certificate, so none is checked
+		{"no", true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			addr := env.start(t, "--tls-auth-clients", tt.mode)
+			for _, client := range []struct {
+				name  string
+				cfg   *tls.Config
+				allow bool
+			}{
+				{"no certificate", env.clientConfig(), tt.noCert},
+				{"trusted certificate", env.clientConfig(env.client), tt.valid},
+				{"untrusted certificate", env.clientConfig(env.untrusted), tt.untrusted},
+			} {
+				reply, err := tlsPing(addr, client.cfg)
+				switch {
+				case client.allow && err != nil:
+					t.Errorf("%s: rejected: %v", client.name, err)
+				case client.allow && reply != "PONG":
+					t.Errorf("%s: got %s, want PONG", client.name, reply)
+				case !client.allow && err == nil:
+					t.Errorf("%s: got %s, want the handshake to fail", client.name, reply)
+				}
+			}
+		})
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	env := newTLSEnv(t)
+	tls12 := env.clientConfig()
+	tls12.MaxVersion = tls.VersionTLS12
+
+	addr := env.start(t, "--tls-auth-clients", "no")
+	if reply, err := tlsPing(addr, tls12); err != nil || reply != "PONG" {
+		t.Fatalf("TLS 1.2 client with the default minimum: %s, %v", reply, err)
+	}
+
+	addr = env.start(t, "--tls-auth-clients", "no", "--tls-min-version", "tlsv1.3")
+	if _, err := tlsPing(addr, tls12); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Fatalf("TLS 1.2 client with tls-min-version tlsv1.3: error %v, want a protocol version alert", err)
+	}
+	conn, err := tls.Dial("tcp", addr, env.clientConfig())
+	if err != nil {
+		t.Fatalf("TLS 1.3 client: %v", err)
+	}
+	defer conn.Close()
+	if v := conn.ConnectionState().Version; v != tls.VersionTLS13 {
+		t.Fatalf("negotiated version %x, want TLS 1.3", v)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	env := newTLSEnv(t)
+	for _, tt := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"missing certificate", []string{"--tls-key-file", env.keyFile}, "tls-cert-file and tls-key-file are required"},
+		{"clients without a CA", []string{"--tls-cert-file", env.certFile, "--tls-key-file", env.keyFile}, "tls-ca-cert-file is required"},
+		{"unreadable key", []string{"--tls-cert-file", env.certFile, "--tls-key-file", env.certFile}, "failed to configure TLS"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.Load(append([]string{"--port", "0", "--appendonly", "no", "--tls-port", freePort(t)}, tt.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := NewServer(cfg)
+			defer s.Close()
+			if err := s.Start(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Start error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
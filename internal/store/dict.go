@@ -0,0 +1,197 @@
+package store
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+// dictMinSize is the fewest buckets a dict has, like DICT_HT_INITIAL_SIZE in Redis.
+const dictMinSize = 4
+
+var dictSeed = maphash.MakeSeed()
+
+/*
+dict is the hashtable encoding of hashes and sets, modelled after the Redis dict: entries are
+chained in a power of two number of buckets picked by the hash of their key. The table doubles
+once it holds more entries than buckets and shrinks again once it is less than an eighth full.
+Unlike a Go map its layout can be walked, so a scan cursor stays valid while the table grows
+or shrinks between calls, and random entries are picked without going over all of them.
+*/
+type dict struct {
+	buckets [][]dictEntry
+	count   int
+}
+
+type dictEntry struct {
+	key, value string // value is empty in a set
+}
+
+// newDict returns an empty dict with room for size entries.
+func newDict(size int) *dict {
+	d := &dict{}
+	d.resize(size)
+	return d
+}
+
+func (d *dict) len() int {
+	return d.count
+}
+
+func (d *dict) bucket(key string) *[]dictEntry {
+	return &d.buckets[maphash.String(dictSeed, key)&uint64(len(d.buckets)-1)]
+}
+
+func (d *dict) get(key string) (string, bool) {
+	for _, e := range *d.bucket(key) {
+		if e.key == key {
+			return e.value, true
+		}
+	}
+	return "", false
+}
+
+// set sets key to value and reports whether the key is new.
+func (d *dict) set(key, value string) bool {
+	b := d.bucket(key)
+	for i := range *b {
+		if (*b)[i].key == key {
+			(*b)[i].value = value
+			return false
+		}
+	}
+	*b = append(*b, dictEntry{key, value})
+	d.count++
+	if d.count > len(d.buckets) {
+		d.resize(d.count)
+	}
+	return true
+}
+
+// del removes key and reports whether it was there.
+func (d *dict) del(key string) bool {
+	b := d.bucket(key)
+	for i := range *b {
+		if (*b)[i].key != key {
+			continue
+		}
+		last := len(*b) - 1
+		(*b)[i] = (*b)[last]
+		if last == 0 {
+			*b = nil
+		} else {
+			(*b)[last] = dictEntry{}
+			*b = (*b)[:last]
+		}
+		d.count--
+		if len(d.buckets) > dictMinSize && d.count*8 < len(d.buckets) {
+			d.resize(d.count)
+		}
+		return true
+	}
+	return false
+}
+
+// resize rehashes the entries into the smallest power of two number of buckets that holds size of them.
+func (d *dict) resize(size int) {
+	n := dictMinSize
+	for n < size {
+		n <<= 1
+	}
+	old := d.buckets
+	d.buckets = make([][]dictEntry, n)
+	for _, b := range old {
+		for _, e := range b {
+			nb := d.bucket(e.key)
+			*nb = append(*nb, e)
+		}
+	}
+}
+
+// each calls fn on every entry, in no particular order.
+func (d *dict) each(fn func(key, value string)) {
+	for _, b := range d.buckets {
+		for _, e := range b {
+			fn(e.key, e.value)
+		}
+	}
+}
+
+/*
+scan returns the entries of the buckets from cursor on, at least count of them unless the
+walk ends first, and the cursor to pass to get the next page, 0 once every bucket has been
+visited. Like dictScan in Redis, the cursor counts up with its bits reversed, so the buckets
+already visited map onto buckets already visited when the table doubles or halves: an entry
+present for the whole walk is always returned, at the cost of a few repeats after a shrink.
+A page visits at most ten buckets per entry asked for, so a sparse table cannot stall it.
+*/
+func (d *dict) scan(cursor uint64, count int) ([]dictEntry, uint64) {
+	count = max(count, 1)
+	mask := uint64(len(d.buckets) - 1)
+	var page []dictEntry
+	for visited := 0; ; {
+		page = append(page, d.buckets[cursor&mask]...)
+		visited++
+
+		// set the bits above the mask so the carry of the reversed increment goes through them
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 || len(page) >= count || visited >= 10*count {
+			return page, cursor
+		}
+	}
+}
+
+/*
+random returns a random entry, d must not be empty. Like dictGetRandomKey in Redis it picks
+random buckets until one is not empty, which a table at least an eighth full soon finds, so
+entries sharing a bucket are a little less likely than the others.
+*/
+func (d *dict) random() dictEntry {
+	for {
+		if b := d.buckets[rand.Intn(len(d.buckets))]; len(b) > 0 {
+			return b[rand.Intn(len(b))]
+		}
+	}
+}
+
+// sample returns count distinct random entries, count must not be more than d holds.
+func (d *dict) sample(count int) []dictEntry {
+	out := make([]dictEntry, 0, count)
+	if count*3 > d.count {
+		// most of the entries, shuffling a copy beats picking them one by one
+		d.each(func(k, v string) {
+			out = append(out, dictEntry{k, v})
+		})
+		rand.Shuffle(len(out), func(i, j int) {
+			out[i], out[j] = out[j], out[i]
+		})
+		return out[:count]
+	}
+	picked := make(map[string]struct{}, count)
+	for len(out) < count {
+		e := d.random()
+		if _, ok := picked[e.key]; !ok {
+			picked[e.key] = struct{}{}
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// sampleIndexes returns count distinct random indexes below n, count must not be more than n.
+func sampleIndexes(n, count int) []int {
+	if count*3 > n {
+		return rand.Perm(n)[:count]
+	}
+	out := make([]int, 0, count)
+	picked := make(map[int]struct{}, count)
+	for len(out) < count {
+		i := rand.Intn(n)
+		if _, ok := picked[i]; !ok {
+			picked[i] = struct{}{}
+			out = append(out, i)
+		}
+	}
+	return out
+}
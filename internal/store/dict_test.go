@@ -0,0 +1,196 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+)
+
+// checkDict checks the count and the load of d against want, the keys and values it should hold.
+func checkDict(t *testing.T, d *dict, want map[string]string) {
+	t.Helper()
+	if d.len() != len(want) {
+		t.Fatalf("len %d, want %d", d.len(), len(want))
+	}
+	n := len(d.buckets)
+	if n&(n-1) != 0 || n < dictMinSize {
+		t.Fatalf("%d buckets, not a power of two of at least %d", n, dictMinSize)
+	}
+	if d.count > n || (n > dictMinSize && d.count*8 < n) {
+		t.Fatalf("%d entries in %d buckets", d.count, n)
+	}
+	seen := 0
+	d.each(func(k, v string) {
+		seen++
+		if want[k] != v {
+			t.Fatalf("%s = %q, want %q", k, v, want[k])
+		}
+	})
+	if seen != len(want) {
+		t.Fatalf("each went over %d entries, want %d", seen, len(want))
+	}
+}
+
+func TestDict(t *testing.T) {
+	d := newDict(0)
+	want := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		k := strconv.Itoa(i)
+		if !d.set(k, "v"+k) {
+			t.Fatalf("set %s: not new", k)
+		}
+		want[k] = "v" + k
+	}
+	if d.set("7", "seven") {
+		t.Fatal("set of an existing key reported it new")
+	}
+	want["7"] = "seven"
+	checkDict(t, d, want)
+	if v, ok := d.get("7"); !ok || v != "seven" {
+		t.Fatalf("get 7 = %q, %v", v, ok)
+	}
+	if _, ok := d.get("1000"); ok {
+		t.Fatal("get of a missing key succeeded")
+	}
+
+	for i := 0; i < 990; i++ {
+		k := strconv.Itoa(i)
+		if !d.del(k) {
+			t.Fatalf("del %s: not found", k)
+		}
+		delete(want, k)
+	}
+	if d.del("0") {
+		t.Fatal("del of a missing key succeeded")
+	}
+	checkDict(t, d, want)
+}
+
+// scanAll walks d with count per page, calling between after each page, and returns how often each key came back.
+func scanAll(t *testing.T, d *dict, count int, between func(page int)) map[string]int {
+	t.Helper()
+	seen := map[string]int{}
+	cursor := uint64(0)
+	for page := 0; ; page++ {
+		if page > 100000 {
+			t.Fatal("the scan never ends")
+		}
+		entries, next := d.scan(cursor, count)
+		for _, e := range entries {
+			seen[e.key]++
+		}
+		if next == 0 {
+			return seen
+		}
+		cursor = next
+		between(page)
+	}
+}
+
+func TestDictScan(t *testing.T) {
+	fill := func(from, to int) *dict {
+		d := newDict(0)
+		for i := from; i < to; i++ {
+			d.set(strconv.Itoa(i), "")
+		}
+		return d
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		d := fill(0, 1000)
+		seen := scanAll(t, d, 10, func(int) {})
+		for i := 0; i < 1000; i++ {
+			if seen[strconv.Itoa(i)] != 1 {
+				t.Fatalf("%d returned %d times", i, seen[strconv.Itoa(i)])
+			}
+		}
+	})
+
+	// the first 100 keys stay for the whole scan while the table grows or shrinks under it
+	t.Run("growing", func(t *testing.T) {
+		d := fill(0, 100)
+		next := 100
+		seen := scanAll(t, d, 5, func(int) {
+			for range 20 {
+				if next < 5000 {
+					d.set(strconv.Itoa(next), "")
+					next++
+				}
+			}
+		})
+		for i := 0; i < 100; i++ {
+			if seen[strconv.Itoa(i)] == 0 {
+				t.Fatalf("%d was never returned", i)
+			}
+		}
+	})
+
+	t.Run("shrinking", func(t *testing.T) {
+		d := fill(0, 10000)
+		gone := 100
+		seen := scanAll(t, d, 5, func(int) {
+			for range 500 {
+				if gone < 10000 {
+					d.del(strconv.Itoa(gone))
+					gone++
+				}
+			}
+		})
+		for i := 0; i < 100; i++ {
+			if seen[strconv.Itoa(i)] == 0 {
+				t.Fatalf("%d was never returned", i)
+			}
+		}
+		if len(d.buckets) >= 1024 {
+			t.Fatalf("%d buckets left for %d entries", len(d.buckets), d.len())
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		d := newDict(0)
+		if entries, next := d.scan(0, 10); len(entries) != 0 || next != 0 {
+			t.Fatalf("scan of an empty dict = %v, %d", entries, next)
+		}
+	})
+}
+
+func TestDictSample(t *testing.T) {
+	d := newDict(0)
+	for i := 0; i < 300; i++ {
+		d.set(strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+
+	// few and many entries take different paths
+	for _, count := range []int{0, 1, 50, 200, 300} {
+		picked := map[string]bool{}
+		for _, e := range d.sample(count) {
+			if v, ok := d.get(e.key); !ok || v != e.value || picked[e.key] {
+				t.Fatalf("sample(%d) returned %v, missing or twice", count, e)
+			}
+			picked[e.key] = true
+		}
+		if len(picked) != count {
+			t.Fatalf("sample(%d) returned %d entries", count, len(picked))
+		}
+	}
+
+	seen := map[string]bool{}
+	for range 10000 {
+		seen[d.random().key] = true
+	}
+	if len(seen) != 300 {
+		t.Fatalf("10000 random entries only covered %d of 300", len(seen))
+	}
+
+	for _, count := range []int{0, 1, 10, 90, 100} {
+		picked := map[int]bool{}
+		for _, i := range sampleIndexes(100, count) {
+			if i < 0 || i >= 100 || picked[i] {
+				t.Fatalf("sampleIndexes(100, %d) returned %d, out of range or twice", count, i)
+			}
+			picked[i] = true
+		}
+		if len(picked) != count {
+			t.Fatalf("sampleIndexes(100, %d) returned %d indexes", count, len(picked))
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+
+	"github.com/blvckbill/redis-from-scratch/internal/glob"
+)
+
+var (
+	ErrHashNotInteger = errors.New("ERR hash value is not an integer")
+	ErrHashNotFloat   = errors.New("ERR hash value is not a float")
+	ErrOverflow       = errors.New("ERR increment or decrement would overflow")
+	ErrNaN            = errors.New("ERR increment would produce NaN or Infinity")
+)
+
+// Defaults of hash-max-listpack-entries and hash-max-listpack-value.
+const (
+	DefaultHashMaxListpackEntries = 128
+	DefaultHashMaxListpackValue   = 64
+)
+
+type hashPair struct {
+	field, value string
+}
+
+/*
+hash holds the fields of a hash. Small hashes use the listpack encoding, a slice of pairs in
+insertion order that is searched linearly but costs little memory; once a hash gets more
+fields than hash-max-listpack-entries, or a field or value longer than hash-max-listpack-value,
+it is converted to the hashtable encoding, a dict, and stays that way. Fields given a TTL by
+HEXPIRE and friends are tracked in expires, see hashexpire.go.
+*/
+type hash struct {
+	pairs []hashPair // listpack encoding
+	table *dict      // hashtable encoding, nil while the hash is a listpack
+
+	expires    map[string]*HeapItem // TTLs of the fields that have one
+	nextExpire int64                // no field expires before this, in unix milliseconds
+}
+
+func (h *hash) len() int {
+	if h.table != nil {
+		return h.table.len()
+	}
+	return len(h.pairs)
+}
+
+func (h *hash) get(field string) (string, bool) {
+	if h.table != nil {
+		return h.table.get(field)
+	}
+	for _, p := range h.pairs {
+		if p.field == field {
+			return p.value, true
+		}
+	}
+	return "", false
+}
+
+// set sets field to value and reports whether the field is new.
+func (h *hash) set(field, value string) bool {
+	if h.table != nil {
+		return h.table.set(field, value)
+	}
+	for i := range h.pairs {
+		if h.pairs[i].field == field {
+			h.pairs[i].value = value
+			return false
+		}
+	}
+	h.pairs = append(h.pairs, hashPair{field, value})
+	return true
+}
+
+// del removes field and reports whether it was there.
+func (h *hash) del(field string) bool {
+	if h.table != nil {
+		return h.table.del(field)
+	}
+	for i := range h.pairs {
+		if h.pairs[i].field == field {
+			h.pairs = append(h.pairs[:i], h.pairs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// all returns every field and value, in insertion order for a listpack.
+func (h *hash) all() []hashPair {
+	if h.table == nil {
+		return append([]hashPair(nil), h.pairs...)
+	}
+	pairs := make([]hashPair, 0, h.table.len())
+	h.table.each(func(f, v string) {
+		pairs = append(pairs, hashPair{f, v})
+	})
+	return pairs
+}
+
+// random returns a random field and its value, h must not be empty.
+func (h *hash) random() hashPair {
+	if h.table != nil {
+		e := h.table.random()
+		return hashPair{e.key, e.value}
+	}
+	return h.pairs[rand.Intn(len(h.pairs))]
+}
+
+// sample returns count distinct random fields and their values, or all of them if h has no more than count.
+func (h *hash) sample(count int) []hashPair {
+	if count >= h.len() {
+		return h.all()
+	}
+	out := make([]hashPair, count)
+	if h.table != nil {
+		for i, e := range h.table.sample(count) {
+			out[i] = hashPair{e.key, e.value}
+		}
+		return out
+	}
+	for i, j := range sampleIndexes(len(h.pairs), count) {
+		out[i] = h.pairs[j]
+	}
+	return out
+}
+
+// convert switches the hash to the hashtable encoding.
+func (h *hash) convert() {
+	h.table = newDict(len(h.pairs))
+	for _, p := range h.pairs {
+		h.table.set(p.field, p.value)
+	}
+	h.pairs = nil
+}
+
+// SetHashMaxListpack sets the limits past which a hash is converted from the listpack to the hashtable encoding.
+func (s *Store) SetHashMaxListpack(entries, value int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hashMaxEntries, s.hashMaxValue = entries, value
+}
+
+// hash returns the hash at key, nil if the key does not exist. It must be called with s.mu held for writing.
+func (s *Store) hash(key string) (*hash, error) {
+	val, ok, err := s.lookup(key, TypeHash)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if !s.expireFields(key, val.hash) {
+		return nil, nil
+	}
+	return val.hash, nil
+}
+
+// peekHash is hash for operations that only read, under rlock, which has already deleted the expired fields.
+func (s *Store) peekHash(key string) (*hash, error) {
+	val, ok, err := s.peek(key, TypeHash)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return val.hash, nil
+}
+
+// hashSet sets a field of the hash at key, creating the hash if needed and converting it once it outgrows the listpack encoding.
+func (s *Store) hashSet(key string, h *hash, field, value string) bool {
+	if h == nil {
+		h = &hash{}
+		s.data[key] = Value{
+			encoding: HashListpackEncoding,
+			hash:     h,
+		}
+	}
+	added := h.set(field, value)
+	if h.table == nil && (h.len() > s.hashMaxEntries || len(field) > s.hashMaxValue || len(value) > s.hashMaxValue) {
+		h.convert()
+		val := s.data[key]
+		val.encoding = HashEncoding
+		s.data[key] = val
+	}
+	return added
+}
+
+// hashChanged records a change to the hash at key, deleting the key once the hash is empty.
+func (s *Store) hashChanged(key string, h *hash) {
+	if h.len() == 0 {
+		s.removeKey(key)
+		return
+	}
+	s.touch(key)
+}
+
+// HSet sets the fields of the hash at key from pairs of field and value, clearing their TTLs, and returns how many fields are new.
+func (s *Store) HSet(key string, pairs ...string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.hash(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if s.hashSet(key, h, pairs[i], pairs[i+1]) {
+			added++
+		}
+		if h == nil {
+			h = s.data[key].hash
+		}
+		s.clearFieldExpiry(h, pairs[i])
+	}
+	s.touch(key)
+	return added, nil
+}
+
+// HSetNX sets field of the hash at key only if it does not exist yet, and reports whether it did.
+func (s *Store) HSetNX(key, field, value string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.hash(key)
+	if err != nil {
+		return false, err
+	}
+	if h != nil {
+		if _, ok := h.get(field); ok {
+			return false, nil
+		}
+	}
+	s.hashSet(key, h, field, value)
+	s.touch(key)
+	return true, nil
+}
+
+func (s *Store) HGet(key, field string) (string, bool, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	h, err := s.peekHash(key)
+	if err != nil || h == nil {
+		return "", false, err
+	}
+	v, ok := h.get(field)
+	return v, ok, nil
+}
+
+// HMGet returns the values of fields in the hash at key, nil for the ones that do not exist.
+func (s *Store) HMGet(key string, fields ...string) ([]*string, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	h, err := s.peekHash(key)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]*string, len(fields))
+	if h == nil {
+		return values, nil
+	}
+	for i, f := range fields {
+		if v, ok := h.get(f); ok {
+			values[i] = &v
+		}
+	}
+	return values, nil
+}
+
+// HGetAll returns the fields of the hash at key and their values, as field, value, field, value...
+func (s *Store) HGetAll(key string) ([]string, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	h, err := s.peekHash(key)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	pairs := h.all()
+	out := make([]string, 0, 2*len(pairs))
+	for _, p := range pairs {
+		out = append(out, p.field, p.value)
+	}
+	return out, nil
+}
+
+// HDel removes fields from the hash at key and returns how many existed.
+func (s *Store) HDel(key string, fields ...string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.hash(key)
+	if err != nil || h == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, f := range fields {
+		if s.hashDel(h, f) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		s.hashChanged(key, h)
+	}
+	return removed, nil
+}
+
+func (s *Store) HExists(key, field string) (bool, error) {
+	_, ok, err := s.HGet(key, field)
+	return ok, err
+}
+
+// HLen returns the number of fields of the hash at key, 0 if it does not exist.
+func (s *Store) HLen(key string) (int, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	h, err := s.peekHash(key)
+	if err != nil || h == nil {
+		return 0, err
+	}
+	return h.len(), nil
+}
+
+// HStrLen returns the length of the value of field in the hash at key, 0 if it does not exist.
+func (s *Store) HStrLen(key, field string) (int, error) {
+	v, _, err := s.HGet(key, field)
+	return len(v), err
+}
+
+// HIncrBy adds delta to the integer value of field in the hash at key, a missing field counting as 0.
+func (s *Store) HIncrBy(key, field string, delta int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.hash(key)
+	if err != nil {
+		return 0, err
+	}
+	var cur int64
+	if h != nil {
+		if v, ok := h.get(field); ok {
+			cur, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0, ErrHashNotInteger
+			}
+		}
+	}
+	if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	cur += delta
+	s.hashSet(key, h, field, strconv.FormatInt(cur, 10))
+	s.touch(key)
+	return cur, nil
+}
+
+/*
+HIncrByFloat adds delta to the floating point value of field in the hash at key, a missing
+field counting as 0, and returns the new value formatted as it is stored. The field keeps its
+TTL, expiresAt is when it expires in unix milliseconds or 0 if it does not.
+*/
+func (s *Store) HIncrByFloat(key, field string, delta float64) (v string, expiresAt int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.hash(key)
+	if err != nil {
+		return "", 0, err
+	}
+	var cur float64
+	if h != nil {
+		if v, ok := h.get(field); ok {
+			cur, err = strconv.ParseFloat(v, 64)
+			if err != nil || math.IsNaN(cur) || math.IsInf(cur, 0) {
+				return "", 0, ErrHashNotFloat
+			}
+		}
+	}
+	cur += delta
+	if math.IsNaN(cur) || math.IsInf(cur, 0) {
+		return "", 0, ErrNaN
+	}
+	v = strconv.FormatFloat(cur, 'f', -1, 64)
+	s.hashSet(key, h, field, v)
+	s.touch(key)
+	if h != nil {
+		if item, ok := h.expires[field]; ok {
+			expiresAt = item.expiresAt
+		}
+	}
+	return v, expiresAt, nil
+}
+
+/*
+HRandField returns random fields of the hash at key, as field, value pairs. A positive count
+returns that many distinct fields, or all of them if the hash is smaller, and a negative one
+returns -count fields that may repeat.
+*/
+func (s *Store) HRandField(key string, count int) ([]string, error) {
+	pairs, all, err := s.hashSample(key, count)
+	if err != nil || len(pairs) == 0 {
+		return nil, err
+	}
+
+	// pick outside the lock, a negative count may ask for many more fields than there are
+	if all {
+		picked := make([]hashPair, -count)
+		for i := range picked {
+			picked[i] = pairs[rand.Intn(len(pairs))]
+		}
+		pairs = picked
+	}
+	out := make([]string, 0, 2*len(pairs))
+	for _, p := range pairs {
+		out = append(out, p.field, p.value)
+	}
+	return out, nil
+}
+
+/*
+hashSample picks the fields HRandField returns for count from the hash at key, only going
+over the fields it returns. A negative count asking for more fields than the hash has gets a
+copy of every field instead, with all set, for the repeats to be picked without the lock.
+*/
+func (s *Store) hashSample(key string, count int) (pairs []hashPair, all bool, err error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	h, err := s.peekHash(key)
+	if err != nil || h == nil {
+		return nil, false, err
+	}
+	if count >= 0 {
+		return h.sample(count), false, nil
+	}
+	if -count > h.len() {
+		return h.all(), true, nil
+	}
+	pairs = make([]hashPair, -count)
+	for i := range pairs {
+		pairs[i] = h.random()
+	}
+	return pairs, false, nil
+}
+
+/*
+HScan returns a page of the fields of the hash at key matching pattern, as field, value
+pairs, and the cursor of the next page, 0 once the scan is complete. A small hash in the
+listpack encoding is returned whole in one page, like Redis does.
+*/
+func (s *Store) HScan(key string, cursor uint64, pattern string, count int) ([]string, uint64, error) {
+	pairs, next, err := s.hscanPage(key, cursor, count)
+	if err != nil || pattern == "" {
+		return pairs, next, err
+	}
+
+	// match after releasing the lock, a pattern can take a while on long fields
+	out := pairs[:0]
+	for i := 0; i < len(pairs); i += 2 {
+		if glob.Match(pattern, pairs[i]) {
+			out = append(out, pairs[i], pairs[i+1])
+		}
+	}
+	return out, next, nil
+}
+
+// hscanPage returns the page of HScan before any pattern is applied.
+func (s *Store) hscanPage(key string, cursor uint64, count int) ([]string, uint64, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	h, err := s.peekHash(key)
+	if err != nil || h == nil {
+		return nil, 0, err
+	}
+
+	if h.table == nil {
+		out := make([]string, 0, 2*len(h.pairs))
+		for _, p := range h.pairs {
+			out = append(out, p.field, p.value)
+		}
+		return out, 0, nil
+	}
+	entries, next := h.table.scan(cursor, count)
+	out := make([]string, 0, 2*len(entries))
+	for _, e := range entries {
+		out = append(out, e.key, e.value)
+	}
+	return out, next, nil
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Per field replies of HExpire, HPersist and HExpireTime, the codes Redis answers with.
+const (
+	FieldMissing = -2 // the field, or the whole key, does not exist
+	FieldNoTTL   = -1 // the field exists but does not expire
+	FieldNotSet  = 0  // the NX, XX, GT or LT condition was not met
+	FieldUpdated = 1  // the TTL was set, or removed by HPersist
+	FieldExpired = 2  // the time was already past so the field was deleted
+)
+
+/*
+Fields with a TTL each get an item in the store's ExpirationHeap right away, so the
+background cleanup reclaims them without anyone reading the hash; the hash keeps the items
+of its fields in expires. Reads purge the expired fields first as well, but only once the
+earliest deadline of the hash may have passed, so hashes without TTLs pay nothing.
+*/
+
+// setFieldExpiry makes field of the hash at key expire at the unix time at, in milliseconds.
+func (s *Store) setFieldExpiry(key string, h *hash, field string, at int64) {
+	if h.nextExpire == 0 || at < h.nextExpire {
+		h.nextExpire = at
+	}
+	if item, ok := h.expires[field]; ok {
+		item.expiresAt = at
+		heap.Fix(&s.evictHeap, item.index)
+		return
+	}
+	item := &HeapItem{
+		key:       key,
+		field:     field,
+		isField:   true,
+		expiresAt: at,
+	}
+	heap.Push(&s.evictHeap, item)
+	if h.expires == nil {
+		h.expires = make(map[string]*HeapItem)
+	}
+	h.expires[field] = item
+}
+
+// clearFieldExpiry removes the TTL of field and reports whether it had one.
+func (s *Store) clearFieldExpiry(h *hash, field string) bool {
+	item, ok := h.expires[field]
+	if !ok {
+		return false
+	}
+	heap.Remove(&s.evictHeap, item.index)
+	delete(h.expires, field)
+	return true
+}
+
+// dropFieldExpiries stops tracking the TTLs of a hash that is deleted or overwritten.
+func (s *Store) dropFieldExpiries(h *hash) {
+	for _, item := range h.expires {
+		heap.Remove(&s.evictHeap, item.index)
+	}
+	h.expires = nil
+}
+
+// hashDel removes field from h along with its TTL and reports whether it was there.
+func (s *Store) hashDel(h *hash, field string) bool {
+	s.clearFieldExpiry(h, field)
+	return h.del(field)
+}
+
+// fieldTracked reports whether item is still the TTL of its field, a stale one must not delete anything.
+func (s *Store) fieldTracked(item *HeapItem) bool {
+	val, ok := s.data[item.key]
+	return ok && val.hash != nil && val.hash.expires[item.field] == item
+}
+
+// expireField deletes the field whose TTL item has passed, and its hash if that was the last field.
+func (s *Store) expireField(item *HeapItem) {
+	h := s.data[item.key].hash
+	s.hashDel(h, item.field)
+	s.hashChanged(item.key, h)
+}
+
+// expireFields deletes the expired fields of the hash at key and reports whether the key is still there.
+func (s *Store) expireFields(key string, h *hash) bool {
+	now := time.Now().UnixMilli()
+	if len(h.expires) == 0 || now < h.nextExpire {
+		return true
+	}
+
+	h.nextExpire = 0
+	expired := false
+	for field, item := range h.expires {
+		if item.expiresAt <= now {
+			s.hashDel(h, field)
+			expired = true
+		} else if h.nextExpire == 0 || item.expiresAt < h.nextExpire {
+			h.nextExpire = item.expiresAt
+		}
+	}
+	if expired {
+		s.hashChanged(key, h)
+	}
+	return h.len() > 0
+}
+
+/*
+HExpire makes fields of the hash at key expire at the unix time at, in milliseconds, and
+returns a code per field. cond is "", or one of NX, XX, GT and LT to only set TTLs where the
+field has none, has one, or where at is later or earlier than the current TTL; a field with
+no TTL counts as expiring never. A time already past deletes the fields instead.
+*/
+func (s *Store) HExpire(key string, at int64, cond string, fields []string) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.hash(key)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]int, len(fields))
+	now := time.Now().UnixMilli()
+	changed := false
+	for i, f := range fields {
+		if h == nil {
+			results[i] = FieldMissing
+			continue
+		}
+		if _, ok := h.get(f); !ok {
+			results[i] = FieldMissing
+			continue
+		}
+
+		cur, has := h.expires[f]
+		ok := true
+		switch cond {
+		case "NX":
+			ok = !has
+		case "XX":
+			ok = has
+		case "GT":
+			ok = has && at > cur.expiresAt
+		case "LT":
+			ok = !has || at < cur.expiresAt
+		}
+		if !ok {
+			results[i] = FieldNotSet
+			continue
+		}
+
+		changed = true
+		if at <= now {
+			s.hashDel(h, f)
+			results[i] = FieldExpired
+			continue
+		}
+		s.setFieldExpiry(key, h, f, at)
+		results[i] = FieldUpdated
+	}
+	if changed {
+		s.hashChanged(key, h)
+	}
+	return results, nil
+}
+
+// HPersist removes the TTL of fields of the hash at key and returns a code per field.
+func (s *Store) HPersist(key string, fields []string) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.hash(key)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]int, len(fields))
+	changed := false
+	for i, f := range fields {
+		switch {
+		case h == nil:
+			results[i] = FieldMissing
+		case s.clearFieldExpiry(h, f):
+			results[i] = FieldUpdated
+			changed = true
+		default:
+			if _, ok := h.get(f); ok {
+				results[i] = FieldNoTTL
+			} else {
+				results[i] = FieldMissing
+			}
+		}
+	}
+	if changed {
+		s.touch(key)
+	}
+	return results, nil
+}
+
+// HExpireTime returns, for each of fields of the hash at key, the unix time in milliseconds it expires at, or a code if there is none.
+func (s *Store) HExpireTime(key string, fields []string) ([]int64, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	h, err := s.peekHash(key)
+	if err != nil {
+		return nil, err
+	}
+
+	times := make([]int64, len(fields))
+	for i, f := range fields {
+		if h == nil {
+			times[i] = FieldMissing
+			continue
+		}
+		if item, ok := h.expires[f]; ok {
+			times[i] = item.expiresAt
+		} else if _, ok := h.get(f); ok {
+			times[i] = FieldNoTTL
+		} else {
+			times[i] = FieldMissing
+		}
+	}
+	return times, nil
+}
@@ -0,0 +1,394 @@
+package store
+
+// SetListMaxListpackSize sets the fill of the nodes of lists created from now on, see quicklist.
+func (s *Store) SetListMaxListpackSize(fill int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listFill = fill
+}
+
+// list returns the list at key, nil if the key does not exist. It must be called with s.mu held for writing.
+func (s *Store) list(key string) (*quicklist, error) {
+	val, ok, err := s.lookup(key, TypeList)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return val.list, nil
+}
+
+// peekList is list for operations that only read, under rlock.
+func (s *Store) peekList(key string) (*quicklist, error) {
+	val, ok, err := s.peek(key, TypeList)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return val.list, nil
+}
+
+// createList stores a new empty list at key and returns it.
+func (s *Store) createList(key string) *quicklist {
+	ql := newQuicklist(s.listFill)
+	s.data[key] = Value{
+		encoding: ListEncoding,
+		list:     ql,
+	}
+	return ql
+}
+
+// listChanged records a change to the list at key, deleting the key once the list is empty.
+func (s *Store) listChanged(key string, ql *quicklist) {
+	if ql.Len() == 0 {
+		s.removeKey(key)
+		return
+	}
+	s.touch(key)
+}
+
+// listIndex turns a possibly negative index into an offset from the head, ok is false if it is out of range.
+func listIndex(index, length int) (int, bool) {
+	if index < 0 {
+		index += length
+	}
+	return index, index >= 0 && index < length
+}
+
+// listRange clamps the inclusive range start..stop, either of which may be negative, to a list of length elements.
+func listRange(start, stop, length int) (int, int, bool) {
+	// handle negative indexes
+	if start < 0 {
+		start = length + start
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+
+	// clamp start
+	if start < 0 {
+		start = 0
+	}
+
+	// clamp stop
+	if stop >= length {
+		stop = length - 1
+	}
+
+	// if start is beyond list or start > stop → empty result
+	if start >= length || start > stop {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+/*
+push adds values to the head, or tail, of the list at key one at a time, so LPUSH a b c
+leaves c first like Redis, and returns the new length. With existing set the list is left
+alone and 0 returned when the key does not exist, which is LPUSHX and RPUSHX.
+*/
+func (s *Store) push(key string, values []string, left, existing bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ql, err := s.list(key)
+	if err != nil {
+		return 0, err
+	}
+	if ql == nil {
+		if existing {
+			return 0, nil
+		}
+		ql = s.createList(key)
+	}
+
+	for _, v := range values {
+		if left {
+			ql.PushHead(v)
+		} else {
+			ql.PushTail(v)
+		}
+	}
+	s.listChanged(key, ql)
+	return ql.Len(), nil
+}
+
+func (s *Store) LPush(key string, values ...string) (int, error) {
+	return s.push(key, values, true, false)
+}
+
+func (s *Store) RPush(key string, values ...string) (int, error) {
+	return s.push(key, values, false, false)
+}
+
+// LPushX is LPush for a list that already exists, a missing key is left alone.
+func (s *Store) LPushX(key string, values ...string) (int, error) {
+	return s.push(key, values, true, true)
+}
+
+// RPushX is RPush for a list that already exists, a missing key is left alone.
+func (s *Store) RPushX(key string, values ...string) (int, error) {
+	return s.push(key, values, false, true)
+}
+
+func (s *Store) LPop(key string) (string, bool, error) {
+	items, err := s.PopN(key, 1, true)
+	if err != nil || len(items) == 0 {
+		return "", false, err
+	}
+	return items[0], true, nil
+}
+
+func (s *Store) RPop(key string) (string, bool, error) {
+	items, err := s.PopN(key, 1, false)
+	if err != nil || len(items) == 0 {
+		return "", false, err
+	}
+	return items[0], true, nil
+}
+
+// PopN removes and returns up to count elements from the head, or the tail when left is false, of the list at key.
+func (s *Store) PopN(key string, count int, left bool) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ql, err := s.list(key)
+	if err != nil || ql == nil {
+		return nil, err
+	}
+
+	items := make([]string, min(count, ql.Len()))
+	for i := range items {
+		if left {
+			items[i], _ = ql.PopHead()
+		} else {
+			items[i], _ = ql.PopTail()
+		}
+	}
+	if len(items) > 0 {
+		s.listChanged(key, ql)
+	}
+	return items, nil
+}
+
+/*
+LMove atomically pops an element from the head, or tail, of the list at src and pushes it
+onto the head, or tail, of the list at dst. Nothing moves if src is empty, and both keys are
+checked to hold lists before anything changes.
+*/
+func (s *Store) LMove(src, dst string, fromLeft, toLeft bool) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, err := s.list(src)
+	if err != nil || from == nil {
+		return "", false, err
+	}
+	to, err := s.list(dst)
+	if err != nil {
+		return "", false, err
+	}
+
+	var item string
+	if fromLeft {
+		item, _ = from.PopHead()
+	} else {
+		item, _ = from.PopTail()
+	}
+	s.listChanged(src, from)
+
+	// when rotating a single element list it was just deleted along with src
+	if to == nil || to.Len() == 0 {
+		to = s.createList(dst)
+	}
+	if toLeft {
+		to.PushHead(item)
+	} else {
+		to.PushTail(item)
+	}
+	s.listChanged(dst, to)
+	return item, true, nil
+}
+
+func (s *Store) LRange(key string, start, stop int) ([]string, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	ql, err := s.peekList(key)
+	if err != nil {
+		return nil, err
+	}
+	if ql == nil {
+		return []string{}, nil
+	}
+
+	start, stop, ok := listRange(start, stop, ql.Len())
+	if !ok {
+		return []string{}, nil
+	}
+	return ql.Range(start, stop), nil
+}
+
+// LLen returns the length of the list at key, 0 if it does not exist.
+func (s *Store) LLen(key string) (int, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	ql, err := s.peekList(key)
+	if err != nil || ql == nil {
+		return 0, err
+	}
+	return ql.Len(), nil
+}
+
+// LIndex returns the element at index in the list at key, negative indexes count from the tail.
+func (s *Store) LIndex(key string, index int) (string, bool, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	ql, err := s.peekList(key)
+	if err != nil || ql == nil {
+		return "", false, err
+	}
+	i, ok := listIndex(index, ql.Len())
+	if !ok {
+		return "", false, nil
+	}
+	return ql.Index(i), true, nil
+}
+
+// LSet replaces the element at index in the list at key.
+func (s *Store) LSet(key string, index int, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ql, err := s.list(key)
+	if err != nil {
+		return err
+	}
+	if ql == nil {
+		return ErrNoSuchKey
+	}
+	i, ok := listIndex(index, ql.Len())
+	if !ok {
+		return ErrOutOfRange
+	}
+	ql.Set(i, value)
+	s.touch(key)
+	return nil
+}
+
+/*
+LInsert inserts value before, or after, the first occurrence of pivot in the list at key and
+returns the new length. It returns -1 if pivot is not in the list and 0 if the key does not exist.
+*/
+func (s *Store) LInsert(key string, before bool, pivot, value string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ql, err := s.list(key)
+	if err != nil || ql == nil {
+		return 0, err
+	}
+
+	at := -1
+	ql.Each(false, func(i int, v string) bool {
+		if v == pivot {
+			at = i
+			return false
+		}
+		return true
+	})
+	if at < 0 {
+		return -1, nil
+	}
+	if !before {
+		at++
+	}
+	ql.Insert(at, value)
+	s.listChanged(key, ql)
+	return ql.Len(), nil
+}
+
+/*
+LRem removes occurrences of value from the list at key and returns how many were removed.
+A positive count removes up to count of them from the head, a negative one from the tail
+and 0 removes all of them.
+*/
+func (s *Store) LRem(key string, count int, value string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ql, err := s.list(key)
+	if err != nil || ql == nil {
+		return 0, err
+	}
+
+	var removed int
+	if count < 0 {
+		removed = ql.RemoveMatching(value, -count, true)
+	} else {
+		removed = ql.RemoveMatching(value, count, false)
+	}
+	if removed > 0 {
+		s.listChanged(key, ql)
+	}
+	return removed, nil
+}
+
+// LTrim keeps only the elements between start and stop, inclusive, of the list at key.
+func (s *Store) LTrim(key string, start, stop int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ql, err := s.list(key)
+	if err != nil || ql == nil {
+		return err
+	}
+
+	start, stop, ok := listRange(start, stop, ql.Len())
+	if !ok {
+		s.removeKey(key)
+		return nil
+	}
+	ql.Trim(start, stop)
+	s.listChanged(key, ql)
+	return nil
+}
+
+/*
+LPos returns the indexes of the elements equal to value in the list at key. rank selects the
+first match to return, counting from the tail when negative, count is the most matches to
+return with 0 meaning all of them, and maxlen is the most elements to compare, 0 meaning the
+whole list.
+*/
+func (s *Store) LPos(key, value string, rank, count, maxlen int) ([]int, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	ql, err := s.peekList(key)
+	if err != nil || ql == nil {
+		return nil, err
+	}
+
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+	var positions []int
+	compared := 0
+	ql.Each(rank < 0, func(i int, v string) bool {
+		if maxlen > 0 && compared == maxlen {
+			return false
+		}
+		compared++
+		if v != value {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		positions = append(positions, i)
+		return count == 0 || len(positions) < count
+	})
+	return positions, nil
+}
@@ -0,0 +1,336 @@
+package store
+
+/*
+quicklist is the list encoding, modelled after the Redis quicklist: a doubly linked list of
+small nodes that each pack a bounded run of elements. Pushes and pops at either end only touch
+the end node, so they take constant time and memory is given back as nodes empty, while
+indexing skips whole nodes at a time.
+fill bounds a node the way list-max-listpack-size does: a positive fill is the most elements
+a node holds, a negative one from -1 to -5 caps its size at 4, 8, 16, 32 or 64 KB. The size
+counts a fixed overhead for every element and such nodes never hold more than qlMaxEntries
+elements, so a list of tiny or empty elements still gets small nodes.
+*/
+type quicklist struct {
+	head, tail *qlNode
+	count      int // elements in the whole list
+	nodes      int
+	fill       int
+}
+
+type qlNode struct {
+	prev, next *qlNode
+	items      []string
+	size       int // bytes of the elements, checked against negative fills
+}
+
+const (
+	// qlEntryOverhead is what an element costs on top of its bytes, the string header in items.
+	qlEntryOverhead = 16
+	// qlMaxEntries bounds the elements of a node under a negative fill, whatever their size.
+	qlMaxEntries = 1024
+)
+
+// DefaultListMaxListpackSize is the default list-max-listpack-size, nodes of at most 8 KB.
+const DefaultListMaxListpackSize = -2
+
+func newQuicklist(fill int) *quicklist {
+	return &quicklist{fill: fill}
+}
+
+func (ql *quicklist) Len() int {
+	return ql.count
+}
+
+// allows reports whether v fits in n without going over the fill, an empty node takes anything.
+func (ql *quicklist) allows(n *qlNode, v string) bool {
+	if len(n.items) == 0 {
+		return true
+	}
+	if ql.fill >= 0 {
+		return len(n.items) < max(ql.fill, 1)
+	}
+	if len(n.items) >= qlMaxEntries {
+		return false
+	}
+	level := min(-ql.fill, 5)
+	return n.size+len(v)+(len(n.items)+1)*qlEntryOverhead <= 4096<<(level-1)
+}
+
+// insertNode links n after prev, or at the head when prev is nil.
+func (ql *quicklist) insertNode(prev, n *qlNode) {
+	n.prev = prev
+	if prev == nil {
+		n.next = ql.head
+		ql.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		ql.tail = n
+	} else {
+		n.next.prev = n
+	}
+	ql.nodes++
+}
+
+func (ql *quicklist) unlinkNode(n *qlNode) {
+	if n.prev == nil {
+		ql.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		ql.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	n.prev, n.next, n.items = nil, nil, nil
+	ql.nodes--
+}
+
+// insertAt inserts v at offset i of node n, which must have room for it.
+func (ql *quicklist) insertAt(n *qlNode, i int, v string) {
+	n.items = append(n.items, "")
+	copy(n.items[i+1:], n.items[i:])
+	n.items[i] = v
+	n.size += len(v)
+	ql.count++
+}
+
+// removeAt removes the element at offset i of node n, dropping the node once it is empty.
+func (ql *quicklist) removeAt(n *qlNode, i int) string {
+	v := n.items[i]
+	copy(n.items[i:], n.items[i+1:])
+	n.items[len(n.items)-1] = ""
+	n.items = n.items[:len(n.items)-1]
+	n.size -= len(v)
+	ql.count--
+	if len(n.items) == 0 {
+		ql.unlinkNode(n)
+	}
+	return v
+}
+
+func (ql *quicklist) PushHead(v string) {
+	if ql.head == nil || !ql.allows(ql.head, v) {
+		ql.insertNode(nil, &qlNode{})
+	}
+	ql.insertAt(ql.head, 0, v)
+}
+
+func (ql *quicklist) PushTail(v string) {
+	if ql.tail == nil || !ql.allows(ql.tail, v) {
+		ql.insertNode(ql.tail, &qlNode{})
+	}
+	ql.insertAt(ql.tail, len(ql.tail.items), v)
+}
+
+func (ql *quicklist) PopHead() (string, bool) {
+	if ql.head == nil {
+		return "", false
+	}
+	return ql.removeAt(ql.head, 0), true
+}
+
+func (ql *quicklist) PopTail() (string, bool) {
+	if ql.tail == nil {
+		return "", false
+	}
+	return ql.removeAt(ql.tail, len(ql.tail.items)-1), true
+}
+
+// locate finds the node holding element i, which must be in range, walking from the nearer end.
+func (ql *quicklist) locate(i int) (*qlNode, int) {
+	if i < ql.count/2 {
+		n := ql.head
+		for i >= len(n.items) {
+			i -= len(n.items)
+			n = n.next
+		}
+		return n, i
+	}
+	i = ql.count - 1 - i
+	n := ql.tail
+	for i >= len(n.items) {
+		i -= len(n.items)
+		n = n.prev
+	}
+	return n, len(n.items) - 1 - i
+}
+
+func (ql *quicklist) Index(i int) string {
+	n, off := ql.locate(i)
+	return n.items[off]
+}
+
+func (ql *quicklist) Set(i int, v string) {
+	n, off := ql.locate(i)
+	n.size += len(v) - len(n.items[off])
+	n.items[off] = v
+}
+
+// Insert inserts v so it becomes element i, i may be Len() to append.
+func (ql *quicklist) Insert(i int, v string) {
+	if i == 0 {
+		ql.PushHead(v)
+		return
+	}
+	if i == ql.count {
+		ql.PushTail(v)
+		return
+	}
+
+	n, off := ql.locate(i)
+	if ql.allows(n, v) {
+		ql.insertAt(n, off, v)
+		return
+	}
+	if off == 0 && n.prev != nil && ql.allows(n.prev, v) {
+		ql.insertAt(n.prev, len(n.prev.items), v)
+		return
+	}
+
+	// split the full node at the insertion point and put v at the end of its first half
+	if off > 0 {
+		rest := &qlNode{items: append([]string(nil), n.items[off:]...)}
+		for _, item := range rest.items {
+			rest.size += len(item)
+		}
+		clear(n.items[off:])
+		n.items = n.items[:off]
+		n.size -= rest.size
+		ql.insertNode(n, rest)
+	} else {
+		n = n.prev
+	}
+	if n == nil || !ql.allows(n, v) {
+		ql.insertNode(n, &qlNode{})
+		if n == nil {
+			n = ql.head
+		} else {
+			n = n.next
+		}
+	}
+	ql.insertAt(n, len(n.items), v)
+}
+
+// Range returns a copy of the elements from start to stop inclusive, both of which must be in range.
+func (ql *quicklist) Range(start, stop int) []string {
+	out := make([]string, 0, stop-start+1)
+	n, off := ql.locate(start)
+	for len(out) < cap(out) {
+		take := min(len(n.items)-off, cap(out)-len(out))
+		out = append(out, n.items[off:off+take]...)
+		n, off = n.next, 0
+	}
+	return out
+}
+
+/*
+Each calls fn with every element and its index, from the head or, if reverse is set, from the
+tail, until fn returns false.
+*/
+func (ql *quicklist) Each(reverse bool, fn func(i int, v string) bool) {
+	if !reverse {
+		i := 0
+		for n := ql.head; n != nil; n = n.next {
+			for _, v := range n.items {
+				if !fn(i, v) {
+					return
+				}
+				i++
+			}
+		}
+		return
+	}
+	i := ql.count - 1
+	for n := ql.tail; n != nil; n = n.prev {
+		for j := len(n.items) - 1; j >= 0; j-- {
+			if !fn(i, n.items[j]) {
+				return
+			}
+			i--
+		}
+	}
+}
+
+/*
+RemoveMatching removes up to limit elements equal to v, all of them if limit is 0, scanning
+from the head or, if reverse is set, from the tail, and returns how many it removed.
+*/
+func (ql *quicklist) RemoveMatching(v string, limit int, reverse bool) int {
+	removed := 0
+	n := ql.head
+	if reverse {
+		n = ql.tail
+	}
+	for n != nil && (limit == 0 || removed < limit) {
+		next := n.next
+		if reverse {
+			next = n.prev
+		}
+		for j := 0; j < len(n.items) && (limit == 0 || removed < limit); {
+			i := j
+			if reverse {
+				i = len(n.items) - 1 - j
+			}
+			if n.items[i] != v {
+				j++
+				continue
+			}
+			// the next candidate is now at the same j, either way the scan goes
+			ql.removeAt(n, i)
+			removed++
+		}
+		n = next
+	}
+	return removed
+}
+
+// Trim keeps only the elements from start to stop inclusive, both of which must be in range.
+func (ql *quicklist) Trim(start, stop int) {
+	ql.dropHead(start)
+	ql.dropTail(ql.count - (stop - start + 1))
+}
+
+// dropHead removes the first k elements, unlinking whole nodes where it can.
+func (ql *quicklist) dropHead(k int) {
+	for k > 0 {
+		n := ql.head
+		if k >= len(n.items) {
+			k -= len(n.items)
+			ql.count -= len(n.items)
+			ql.unlinkNode(n)
+			continue
+		}
+		for _, item := range n.items[:k] {
+			n.size -= len(item)
+		}
+		n.items = append(n.items[:0], n.items[k:]...)
+		clear(n.items[len(n.items):cap(n.items)])
+		ql.count -= k
+		return
+	}
+}
+
+// dropTail removes the last k elements, unlinking whole nodes where it can.
+func (ql *quicklist) dropTail(k int) {
+	for k > 0 {
+		n := ql.tail
+		if k >= len(n.items) {
+			k -= len(n.items)
+			ql.count -= len(n.items)
+			ql.unlinkNode(n)
+			continue
+		}
+		keep := len(n.items) - k
+		for _, item := range n.items[keep:] {
+			n.size -= len(item)
+		}
+		clear(n.items[keep:])
+		n.items = n.items[:keep]
+		ql.count -= k
+		return
+	}
+}
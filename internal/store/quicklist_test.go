@@ -0,0 +1,436 @@
+package store
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// checkQuicklist fails the test unless ql holds want and its nodes are consistent and within the fill.
+func checkQuicklist(t *testing.T, ql *quicklist, want []string) {
+	t.Helper()
+	var got []string
+	nodes := 0
+	var prev *qlNode
+	for n := ql.head; n != nil; n = n.next {
+		nodes++
+		if n.prev != prev {
+			t.Fatalf("node %d: broken prev link", nodes)
+		}
+		if len(n.items) == 0 {
+			t.Fatalf("node %d is empty", nodes)
+		}
+		size := 0
+		for _, item := range n.items {
+			size += len(item)
+		}
+		if size != n.size {
+			t.Fatalf("node %d: size %d, its elements add up to %d", nodes, n.size, size)
+		}
+		if ql.fill > 0 && len(n.items) > ql.fill {
+			t.Fatalf("node %d: %d elements with a fill of %d", nodes, len(n.items), ql.fill)
+		}
+		if ql.fill < 0 && len(n.items) > 1 {
+			bytes := n.size + len(n.items)*qlEntryOverhead
+			if limit := 4096 << (min(-ql.fill, 5) - 1); bytes > limit {
+				t.Fatalf("node %d: %d bytes over the %d byte limit", nodes, bytes, limit)
+			}
+			if len(n.items) > qlMaxEntries {
+				t.Fatalf("node %d: %d elements over the limit of %d", nodes, len(n.items), qlMaxEntries)
+			}
+		}
+		got = append(got, n.items...)
+		prev = n
+	}
+	if ql.tail != prev {
+		t.Fatal("tail is not the last node")
+	}
+	if nodes != ql.nodes {
+		t.Fatalf("%d nodes counted as %d", nodes, ql.nodes)
+	}
+	if ql.Len() != len(got) {
+		t.Fatalf("Len() = %d with %d elements", ql.Len(), len(got))
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("list is %q, want %q", got, want)
+	}
+}
+
+// numbered returns a quicklist and its expected contents holding "0" to "n-1".
+func numbered(fill, n int) (*quicklist, []string) {
+	ql := newQuicklist(fill)
+	want := make([]string, n)
+	for i := range want {
+		want[i] = strconv.Itoa(i)
+		ql.PushTail(want[i])
+	}
+	return ql, want
+}
+
+func TestQuicklistPushPop(t *testing.T) {
+	ql := newQuicklist(3)
+	var want []string
+	for i := 0; i < 10; i++ {
+		ql.PushTail("t" + strconv.Itoa(i))
+		ql.PushHead("h" + strconv.Itoa(i))
+		want = append([]string{"h" + strconv.Itoa(i)}, append(want, "t"+strconv.Itoa(i))...)
+	}
+	checkQuicklist(t, ql, want)
+
+	for len(want) > 0 {
+		if v, ok := ql.PopHead(); !ok || v != want[0] {
+			t.Fatalf("PopHead = %q, %v, want %q", v, ok, want[0])
+		}
+		want = want[1:]
+		if len(want) == 0 {
+			break
+		}
+		if v, ok := ql.PopTail(); !ok || v != want[len(want)-1] {
+			t.Fatalf("PopTail = %q, %v, want %q", v, ok, want[len(want)-1])
+		}
+		want = want[:len(want)-1]
+		checkQuicklist(t, ql, want)
+	}
+	checkQuicklist(t, ql, nil)
+	if _, ok := ql.PopHead(); ok {
+		t.Fatal("PopHead on an empty list")
+	}
+	if _, ok := ql.PopTail(); ok {
+		t.Fatal("PopTail on an empty list")
+	}
+}
+
+func TestQuicklistLocateAcrossNodes(t *testing.T) {
+	ql, want := numbered(4, 23)
+	if ql.nodes != 6 {
+		t.Fatalf("%d nodes, want 6", ql.nodes)
+	}
+	// every index from both halves, including the first and last of each node
+	for i, v := range want {
+		n, off := ql.locate(i)
+		if n.items[off] != v || ql.Index(i) != v {
+			t.Fatalf("element %d located as %q, want %q", i, n.items[off], v)
+		}
+	}
+	for i := range want {
+		want[i] = "x" + want[i]
+		ql.Set(i, want[i])
+	}
+	checkQuicklist(t, ql, want)
+
+	if got := ql.Range(2, 17); !slices.Equal(got, want[2:18]) {
+		t.Fatalf("Range(2, 17) = %q", got)
+	}
+}
+
+func TestQuicklistInsertSplitsNode(t *testing.T) {
+	tests := []struct {
+		name  string
+		at    int
+		nodes int
+	}{
+		// [0 1 2 3] [4 5 6 7]: splitting the full node leaves x at the end of its first half
+		{"middle of a full node", 2, 3},
+		{"last element of a full node", 3, 3},
+		// x goes at the start of the second node, but both are full so it gets its own
+		{"node boundary", 4, 3},
+		{"head", 0, 3},
+		{"tail", 8, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ql, want := numbered(4, 8)
+			ql.Insert(tt.at, "x")
+			want = slices.Insert(want, tt.at, "x")
+			checkQuicklist(t, ql, want)
+			if ql.nodes != tt.nodes {
+				t.Fatalf("%d nodes, want %d", ql.nodes, tt.nodes)
+			}
+		})
+	}
+
+	t.Run("room in the previous node", func(t *testing.T) {
+		ql, want := numbered(4, 8)
+		ql.PopHead()
+		ql.Insert(3, "x")
+		checkQuicklist(t, ql, slices.Insert(want[1:], 3, "x"))
+		if ql.nodes != 2 {
+			t.Fatalf("%d nodes, want 2", ql.nodes)
+		}
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		// 1 KB elements, four fit in the 4 KB nodes of fill -1
+		ql := newQuicklist(-1)
+		var want []string
+		for i := 0; i < 8; i++ {
+			v := strings.Repeat(strconv.Itoa(i), 1000)
+			ql.PushTail(v)
+			want = append(want, v)
+		}
+		big := strings.Repeat("x", 5000)
+		ql.Insert(6, "y")
+		ql.Insert(1, big)
+		want = slices.Insert(slices.Insert(want, 6, "y"), 1, big)
+		checkQuicklist(t, ql, want)
+	})
+
+	t.Run("empty elements", func(t *testing.T) {
+		// empty elements still take room, they don't all end up in one node
+		for fill := -1; fill >= -5; fill-- {
+			ql := newQuicklist(fill)
+			want := make([]string, 20000)
+			for range want {
+				ql.PushHead("")
+			}
+			checkQuicklist(t, ql, want)
+			if limit := 20000/min(4096<<(-fill-1)/qlEntryOverhead, qlMaxEntries) + 1; ql.nodes > limit {
+				t.Fatalf("fill %d: %d nodes, want at most %d", fill, ql.nodes, limit)
+			}
+			if ql.nodes < 2 {
+				t.Fatalf("fill %d: all the elements are in one node", fill)
+			}
+		}
+	})
+}
+
+func TestQuicklistTrim(t *testing.T) {
+	for _, r := range [][2]int{{0, 22}, {0, 0}, {22, 22}, {3, 4}, {4, 7}, {5, 18}, {1, 21}, {8, 11}} {
+		ql, want := numbered(4, 23)
+		ql.Trim(r[0], r[1])
+		checkQuicklist(t, ql, want[r[0]:r[1]+1])
+		ql.PushHead("h")
+		ql.PushTail("t")
+		checkQuicklist(t, ql, append(append([]string{"h"}, want[r[0]:r[1]+1]...), "t"))
+	}
+}
+
+func TestQuicklistRemoveMatching(t *testing.T) {
+	// a b a a | b a a a | a b a
+	items := strings.Split("abaabaaaaba", "")
+	tests := []struct {
+		v       string
+		limit   int
+		reverse bool
+		want    string
+	}{
+		{"a", 0, false, "bbb"},
+		{"a", 3, false, "bbaaaaba"},
+		{"a", 6, false, "bbaba"},
+		{"a", 4, true, "abaabab"},
+		{"b", 1, true, "abaabaaaaa"},
+		{"b", 0, true, "aaaaaaaa"},
+		{"c", 0, false, "abaabaaaaba"},
+	}
+	for _, tt := range tests {
+		ql := newQuicklist(4)
+		for _, item := range items {
+			ql.PushTail(item)
+		}
+		removed := ql.RemoveMatching(tt.v, tt.limit, tt.reverse)
+		want := strings.Split(tt.want, "")
+		if removed != len(items)-len(want) {
+			t.Errorf("RemoveMatching(%q, %d, %v) = %d, want %d", tt.v, tt.limit, tt.reverse, removed, len(items)-len(want))
+		}
+		checkQuicklist(t, ql, want)
+	}
+}
+
+// TestQuicklistRandomOps runs random operations on a quicklist and a plain slice and compares them.
+func TestQuicklistRandomOps(t *testing.T) {
+	for _, fill := range []int{1, 2, 5, 128, -1} {
+		rng := rand.New(rand.NewSource(int64(fill)))
+		ql := newQuicklist(fill)
+		var want []string
+		value := func() string {
+			if fill < 0 {
+				return strings.Repeat(strconv.Itoa(rng.Intn(4)), rng.Intn(3000))
+			}
+			return strconv.Itoa(rng.Intn(4))
+		}
+		for step := 0; step < 5000; step++ {
+			switch op := rng.Intn(10); {
+			case op < 2:
+				v := value()
+				ql.PushHead(v)
+				want = slices.Insert(want, 0, v)
+			case op < 4:
+				v := value()
+				ql.PushTail(v)
+				want = append(want, v)
+			case op < 6:
+				i := rng.Intn(len(want) + 1)
+				v := value()
+				ql.Insert(i, v)
+				want = slices.Insert(want, i, v)
+			case op == 6 && len(want) > 0:
+				ql.PopHead()
+				want = want[1:]
+			case op == 7 && len(want) > 0:
+				ql.PopTail()
+				want = want[:len(want)-1]
+			case op == 8 && len(want) > 0:
+				start := rng.Intn(len(want))
+				stop := start + rng.Intn(len(want)-start)
+				if rng.Intn(4) == 0 {
+					// trims shrink the list a lot, so most of the time only read the range
+					ql.Trim(start, stop)
+					want = slices.Clone(want[start : stop+1])
+				} else if got := ql.Range(start, stop); !slices.Equal(got, want[start:stop+1]) {
+					t.Fatalf("fill %d: Range(%d, %d) = %q, want %q", fill, start, stop, got, want[start:stop+1])
+				}
+			case op == 9:
+				v := value()
+				limit := rng.Intn(3)
+				reverse := rng.Intn(2) == 0
+				before := len(want)
+				want = removeMatching(want, v, limit, reverse)
+				if removed := ql.RemoveMatching(v, limit, reverse); removed != before-len(want) {
+					t.Fatalf("fill %d: RemoveMatching removed %d, want %d", fill, removed, before-len(want))
+				}
+			}
+			if step%50 == 0 {
+				checkQuicklist(t, ql, want)
+			}
+		}
+		checkQuicklist(t, ql, want)
+	}
+}
+
+// removeMatching is RemoveMatching on a slice.
+func removeMatching(list []string, v string, limit int, reverse bool) []string {
+	removed := 0
+	out := make([]string, 0, len(list))
+	for j := range list {
+		i := j
+		if reverse {
+			i = len(list) - 1 - j
+		}
+		if list[i] == v && (limit == 0 || removed < limit) {
+			removed++
+			continue
+		}
+		out = append(out, list[i])
+	}
+	if reverse {
+		slices.Reverse(out)
+	}
+	return out
+}
+
+/*
+sliceList is how lists were stored before the quicklist, a single slice of elements, kept
+here as the baseline for the benchmarks.
+*/
+type sliceList struct {
+	items []string
+}
+
+func (l *sliceList) PushHead(v string) {
+	l.items = append([]string{v}, l.items...)
+}
+
+func (l *sliceList) PushTail(v string) {
+	l.items = append(l.items, v)
+}
+
+func (l *sliceList) PopHead() (string, bool) {
+	if len(l.items) == 0 {
+		return "", false
+	}
+	v := l.items[0]
+	l.items = l.items[1:]
+	return v, true
+}
+
+func (l *sliceList) Index(i int) string {
+	return l.items[i]
+}
+
+func (l *sliceList) Insert(i int, v string) {
+	list := make([]string, 0, len(l.items)+1)
+	list = append(list, l.items[:i]...)
+	list = append(list, v)
+	l.items = append(list, l.items[i:]...)
+}
+
+// benchList is what the benchmarks need from both implementations.
+type benchList interface {
+	PushHead(v string)
+	PushTail(v string)
+	PopHead() (string, bool)
+	Index(i int) string
+	Insert(i int, v string)
+}
+
+var benchLists = []struct {
+	name string
+	new  func() benchList
+}{
+	{"quicklist", func() benchList { return newQuicklist(DefaultListMaxListpackSize) }},
+	{"slice", func() benchList { return &sliceList{} }},
+}
+
+const benchListLen = 10000
+
+func filledBenchList(newList func() benchList) benchList {
+	l := newList()
+	for i := 0; i < benchListLen; i++ {
+		l.PushTail("element:" + strconv.Itoa(i))
+	}
+	return l
+}
+
+// BenchmarkListPushHead pushes to and pops from the head, the way LPUSH and LPOP use a list, at a steady length.
+func BenchmarkListPushHead(b *testing.B) {
+	for _, bl := range benchLists {
+		b.Run(bl.name, func(b *testing.B) {
+			l := filledBenchList(bl.new)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.PushHead("element")
+				l.PopHead()
+			}
+		})
+	}
+}
+
+// BenchmarkListQueue pushes at the tail and pops at the head, the way a job queue uses a list.
+func BenchmarkListQueue(b *testing.B) {
+	for _, bl := range benchLists {
+		b.Run(bl.name, func(b *testing.B) {
+			l := filledBenchList(bl.new)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.PushTail("element")
+				l.PopHead()
+			}
+		})
+	}
+}
+
+func BenchmarkListIndex(b *testing.B) {
+	for _, bl := range benchLists {
+		b.Run(bl.name, func(b *testing.B) {
+			l := filledBenchList(bl.new)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.Index(i % benchListLen)
+			}
+		})
+	}
+}
+
+func BenchmarkListInsertMiddle(b *testing.B) {
+	for _, bl := range benchLists {
+		b.Run(bl.name, func(b *testing.B) {
+			l := filledBenchList(bl.new)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.Insert(benchListLen/2, "element")
+			}
+		})
+	}
+}
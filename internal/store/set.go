@@ -0,0 +1,604 @@
+package store
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+
+	"github.com/blvckbill/redis-from-scratch/internal/glob"
+)
+
+// Default of set-max-intset-entries.
+const DefaultSetMaxIntsetEntries = 512
+
+/*
+set holds the members of a set. A set whose members are all integers, and no more than
+set-max-intset-entries of them, uses the intset encoding, a sorted slice of int64 searched
+by bisection; the first member that is not an integer, or one member too many, converts it
+to the hashtable encoding, a dict, and it stays that way.
+*/
+type set struct {
+	ints  []int64 // intset encoding
+	table *dict   // hashtable encoding, nil while the set is an intset
+}
+
+// intMember returns the integer a member stands for, ok is false unless it is written the way FormatInt would.
+func intMember(member string) (int64, bool) {
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
+
+func (st *set) len() int {
+	if st.table != nil {
+		return st.table.len()
+	}
+	return len(st.ints)
+}
+
+func (st *set) has(member string) bool {
+	if st.table != nil {
+		_, ok := st.table.get(member)
+		return ok
+	}
+	n, ok := intMember(member)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(st.ints, n)
+	return found
+}
+
+// add adds member, which must fit the encoding of the set, and reports whether it is new.
+func (st *set) add(member string) bool {
+	if st.table != nil {
+		return st.table.set(member, "")
+	}
+	n, _ := intMember(member)
+	i, found := slices.BinarySearch(st.ints, n)
+	if found {
+		return false
+	}
+	st.ints = slices.Insert(st.ints, i, n)
+	return true
+}
+
+// remove removes member and reports whether it was there.
+func (st *set) remove(member string) bool {
+	if st.table != nil {
+		return st.table.del(member)
+	}
+	n, ok := intMember(member)
+	if !ok {
+		return false
+	}
+	i, found := slices.BinarySearch(st.ints, n)
+	if !found {
+		return false
+	}
+	st.ints = slices.Delete(st.ints, i, i+1)
+	return true
+}
+
+// members returns every member, in ascending order for an intset.
+func (st *set) members() []string {
+	out := make([]string, 0, st.len())
+	if st.table != nil {
+		st.table.each(func(m, _ string) {
+			out = append(out, m)
+		})
+		return out
+	}
+	for _, n := range st.ints {
+		out = append(out, strconv.FormatInt(n, 10))
+	}
+	return out
+}
+
+// random returns a random member, st must not be empty.
+func (st *set) random() string {
+	if st.table != nil {
+		return st.table.random().key
+	}
+	return strconv.FormatInt(st.ints[rand.Intn(len(st.ints))], 10)
+}
+
+// sample returns count distinct random members, or all of them if st has no more than count.
+func (st *set) sample(count int) []string {
+	if count >= st.len() {
+		return st.members()
+	}
+	out := make([]string, count)
+	if st.table != nil {
+		for i, e := range st.table.sample(count) {
+			out[i] = e.key
+		}
+		return out
+	}
+	for i, j := range sampleIndexes(len(st.ints), count) {
+		out[i] = strconv.FormatInt(st.ints[j], 10)
+	}
+	return out
+}
+
+// pop removes count distinct random members, or all of them if st has no more than count, and returns them.
+func (st *set) pop(count int) []string {
+	if st.table != nil {
+		popped := st.sample(count)
+		for _, m := range popped {
+			st.table.del(m)
+		}
+		return popped
+	}
+
+	// take the picked integers out in a single pass
+	picked := make([]bool, len(st.ints))
+	for _, i := range sampleIndexes(len(st.ints), min(count, len(st.ints))) {
+		picked[i] = true
+	}
+	var popped []string
+	kept := st.ints[:0]
+	for i, n := range st.ints {
+		if picked[i] {
+			popped = append(popped, strconv.FormatInt(n, 10))
+		} else {
+			kept = append(kept, n)
+		}
+	}
+	st.ints = kept
+	return popped
+}
+
+// convert switches the set to the hashtable encoding.
+func (st *set) convert() {
+	st.table = newDict(len(st.ints))
+	for _, n := range st.ints {
+		st.table.set(strconv.FormatInt(n, 10), "")
+	}
+	st.ints = nil
+}
+
+// SetSetMaxIntsetEntries sets the size past which an intset is converted to the hashtable encoding.
+func (s *Store) SetSetMaxIntsetEntries(entries int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setMaxIntset = entries
+}
+
+// set returns the set at key, nil if the key does not exist. It must be called with s.mu held for writing.
+func (s *Store) set(key string) (*set, error) {
+	val, ok, err := s.lookup(key, TypeSet)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return val.set, nil
+}
+
+// peekSet is set for operations that only read, under rlock.
+func (s *Store) peekSet(key string) (*set, error) {
+	val, ok, err := s.peek(key, TypeSet)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return val.set, nil
+}
+
+// setAdd adds member to the set at key, creating the set if needed and converting it once it outgrows the intset encoding.
+func (s *Store) setAdd(key string, st *set, member string) bool {
+	if st == nil {
+		st = &set{}
+		s.data[key] = Value{
+			encoding: SetIntsetEncoding,
+			set:      st,
+		}
+	}
+	if st.table == nil {
+		if _, ok := intMember(member); !ok || (!st.has(member) && st.len() >= s.setMaxIntset) {
+			st.convert()
+			val := s.data[key]
+			val.encoding = SetEncoding
+			s.data[key] = val
+		}
+	}
+	return st.add(member)
+}
+
+// setChanged records a change to the set at key, deleting the key once the set is empty.
+func (s *Store) setChanged(key string, st *set) {
+	if st.len() == 0 {
+		s.removeKey(key)
+		return
+	}
+	s.touch(key)
+}
+
+// storeSet replaces whatever is at key with a set of members, or deletes key if there are none.
+func (s *Store) storeSet(key string, members []string) {
+	s.removeKey(key)
+	if len(members) == 0 {
+		return
+	}
+	var st *set
+	for _, m := range members {
+		s.setAdd(key, st, m)
+		if st == nil {
+			st = s.data[key].set
+		}
+	}
+}
+
+// SAdd adds members to the set at key and returns how many were not already in it.
+func (s *Store) SAdd(key string, members ...string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.set(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, m := range members {
+		if s.setAdd(key, st, m) {
+			added++
+		}
+		if st == nil {
+			st = s.data[key].set
+		}
+	}
+	if added > 0 {
+		s.touch(key)
+	}
+	return added, nil
+}
+
+// SRem removes members from the set at key and returns how many were in it.
+func (s *Store) SRem(key string, members ...string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.set(key)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, m := range members {
+		if st.remove(m) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		s.setChanged(key, st)
+	}
+	return removed, nil
+}
+
+func (s *Store) SMembers(key string) ([]string, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	st, err := s.peekSet(key)
+	if err != nil || st == nil {
+		return nil, err
+	}
+	return st.members(), nil
+}
+
+// SMIsMember reports for each of members whether it is in the set at key.
+func (s *Store) SMIsMember(key string, members ...string) ([]bool, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	st, err := s.peekSet(key)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(members))
+	if st == nil {
+		return found, nil
+	}
+	for i, m := range members {
+		found[i] = st.has(m)
+	}
+	return found, nil
+}
+
+func (s *Store) SIsMember(key, member string) (bool, error) {
+	found, err := s.SMIsMember(key, member)
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+// SCard returns the number of members of the set at key, 0 if it does not exist.
+func (s *Store) SCard(key string) (int, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	st, err := s.peekSet(key)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	return st.len(), nil
+}
+
+// SPop removes and returns up to count random members of the set at key.
+func (s *Store) SPop(key string, count int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.set(key)
+	if err != nil || st == nil {
+		return nil, err
+	}
+	popped := st.pop(count)
+	if len(popped) > 0 {
+		s.setChanged(key, st)
+	}
+	return popped, nil
+}
+
+/*
+SRandMember returns random members of the set at key. A positive count returns that many
+distinct members, or all of them if the set is smaller, and a negative one returns -count
+members that may repeat.
+*/
+func (s *Store) SRandMember(key string, count int) ([]string, error) {
+	members, all, err := s.setSample(key, count)
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+
+	// pick outside the lock, a negative count may ask for many more members than there are
+	if all {
+		picked := make([]string, -count)
+		for i := range picked {
+			picked[i] = members[rand.Intn(len(members))]
+		}
+		members = picked
+	}
+	return members, nil
+}
+
+/*
+setSample picks the members SRandMember returns for count from the set at key, only going
+over the members it returns. A negative count asking for more members than the set has gets
+every member instead, with all set, for the repeats to be picked without the lock.
+*/
+func (s *Store) setSample(key string, count int) (members []string, all bool, err error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	st, err := s.peekSet(key)
+	if err != nil || st == nil {
+		return nil, false, err
+	}
+	if count >= 0 {
+		return st.sample(count), false, nil
+	}
+	if -count > st.len() {
+		return st.members(), true, nil
+	}
+	members = make([]string, -count)
+	for i := range members {
+		members[i] = st.random()
+	}
+	return members, false, nil
+}
+
+/*
+SMove moves member from the set at src to the set at dst and reports whether it was in src.
+Both keys are checked to hold sets before anything changes.
+*/
+func (s *Store) SMove(src, dst, member string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, err := s.set(src)
+	if err != nil {
+		return false, err
+	}
+	to, err := s.set(dst)
+	if err != nil {
+		return false, err
+	}
+	if from == nil || !from.has(member) {
+		return false, nil
+	}
+	if src == dst {
+		return true, nil
+	}
+	from.remove(member)
+	s.setChanged(src, from)
+	if s.setAdd(dst, to, member) {
+		s.touch(dst)
+	}
+	return true, nil
+}
+
+// Set operations of SInter, SUnion and SDiff.
+const (
+	setInter = iota
+	setUnion
+	setDiff
+)
+
+/*
+setAlgebra combines the sets at keys with op, a missing key counting as an empty set, and
+returns the members of the result. It must be called with s.mu held, for reading under rlock.
+*/
+func (s *Store) setAlgebra(op int, keys []string) ([]string, error) {
+	sets := make([]*set, len(keys))
+	for i, key := range keys {
+		st, err := s.peekSet(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = st
+	}
+
+	var out []string
+	switch op {
+	case setInter:
+		// walk the smallest set and keep what every other one has
+		for _, st := range sets {
+			if st == nil {
+				return nil, nil
+			}
+		}
+		slices.SortFunc(sets, func(a, b *set) int { return a.len() - b.len() })
+		for _, m := range sets[0].members() {
+			if inAll(sets[1:], m) {
+				out = append(out, m)
+			}
+		}
+	case setUnion:
+		seen := make(map[string]struct{})
+		for _, st := range sets {
+			if st == nil {
+				continue
+			}
+			for _, m := range st.members() {
+				if _, ok := seen[m]; !ok {
+					seen[m] = struct{}{}
+					out = append(out, m)
+				}
+			}
+		}
+	case setDiff:
+		if sets[0] == nil {
+			return nil, nil
+		}
+		for _, m := range sets[0].members() {
+			if !inAny(sets[1:], m) {
+				out = append(out, m)
+			}
+		}
+	}
+	return out, nil
+}
+
+func inAll(sets []*set, member string) bool {
+	for _, st := range sets {
+		if !st.has(member) {
+			return false
+		}
+	}
+	return true
+}
+
+func inAny(sets []*set, member string) bool {
+	for _, st := range sets {
+		if st != nil && st.has(member) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) SInter(keys ...string) ([]string, error) {
+	unlock := s.rlock(keys...)
+	defer unlock()
+
+	return s.setAlgebra(setInter, keys)
+}
+
+func (s *Store) SUnion(keys ...string) ([]string, error) {
+	unlock := s.rlock(keys...)
+	defer unlock()
+
+	return s.setAlgebra(setUnion, keys)
+}
+
+// SDiff returns the members of the set at the first key that are in none of the others.
+func (s *Store) SDiff(keys ...string) ([]string, error) {
+	unlock := s.rlock(keys...)
+	defer unlock()
+
+	return s.setAlgebra(setDiff, keys)
+}
+
+// algebraStore stores the result of setAlgebra at dst, replacing whatever was there, and returns its size.
+func (s *Store) algebraStore(op int, dst string, keys []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members, err := s.setAlgebra(op, keys)
+	if err != nil {
+		return 0, err
+	}
+	s.storeSet(dst, members)
+	return len(members), nil
+}
+
+func (s *Store) SInterStore(dst string, keys ...string) (int, error) {
+	return s.algebraStore(setInter, dst, keys)
+}
+
+func (s *Store) SUnionStore(dst string, keys ...string) (int, error) {
+	return s.algebraStore(setUnion, dst, keys)
+}
+
+func (s *Store) SDiffStore(dst string, keys ...string) (int, error) {
+	return s.algebraStore(setDiff, dst, keys)
+}
+
+// SInterCard returns the size of the intersection of the sets at keys, counting no further than limit unless it is 0.
+func (s *Store) SInterCard(limit int, keys ...string) (int, error) {
+	unlock := s.rlock(keys...)
+	defer unlock()
+
+	members, err := s.setAlgebra(setInter, keys)
+	if err != nil {
+		return 0, err
+	}
+	if limit > 0 {
+		return min(limit, len(members)), nil
+	}
+	return len(members), nil
+}
+
+/*
+SScan returns a page of the members of the set at key matching pattern and the cursor of
+the next page, 0 once the scan is complete. An intset is returned whole in one page, like
+Redis does.
+*/
+func (s *Store) SScan(key string, cursor uint64, pattern string, count int) ([]string, uint64, error) {
+	members, next, err := s.sscanPage(key, cursor, count)
+	if err != nil || pattern == "" {
+		return members, next, err
+	}
+
+	// match after releasing the lock, a pattern can take a while on long members
+	out := members[:0]
+	for _, m := range members {
+		if glob.Match(pattern, m) {
+			out = append(out, m)
+		}
+	}
+	return out, next, nil
+}
+
+// sscanPage returns the page of SScan before any pattern is applied.
+func (s *Store) sscanPage(key string, cursor uint64, count int) ([]string, uint64, error) {
+	unlock := s.rlock(key)
+	defer unlock()
+
+	st, err := s.peekSet(key)
+	if err != nil || st == nil {
+		return nil, 0, err
+	}
+
+	if st.table == nil {
+		return st.members(), 0, nil
+	}
+	entries, next := st.table.scan(cursor, count)
+	members := make([]string, len(entries))
+	for i, e := range entries {
+		members[i] = e.key
+	}
+	return members, next, nil
+}
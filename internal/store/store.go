@@ -2,9 +2,9 @@ package store
 
 import (
 	"container/heap"
-	"fmt"
-	"log"
+	"errors"
 	"math/rand"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -16,13 +16,50 @@ const (
 	StringEncoding Encoding = iota
 	IntEncoding
 	ListEncoding
+	HashListpackEncoding
+	HashEncoding
+	SetIntsetEncoding
+	SetEncoding
+)
+
+// Type is the data type of a value as TYPE reports it, each type may be stored in several encodings.
+type Type string
+
+const (
+	TypeString Type = "string"
+	TypeList   Type = "list"
+	TypeHash   Type = "hash"
+	TypeSet    Type = "set"
+)
+
+// Type returns the data type the encoding stores.
+func (e Encoding) Type() Type {
+	switch e {
+	case ListEncoding:
+		return TypeList
+	case HashListpackEncoding, HashEncoding:
+		return TypeHash
+	case SetIntsetEncoding, SetEncoding:
+		return TypeSet
+	default:
+		return TypeString
+	}
+}
+
+var (
+	ErrWrongType  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+	ErrNoSuchKey  = errors.New("ERR no such key")
+	ErrOutOfRange = errors.New("ERR index out of range")
 )
 
 type Value struct {
 	encoding  Encoding
 	strVal    string
 	intVal    int64
-	listVal   []string
+	list      *quicklist
+	hash      *hash
+	set       *set
 	expiresAt int64 // stored in milliseconds
 }
 
@@ -31,6 +68,23 @@ type Store struct {
 	data      map[string]Value
 	evictHeap ExpirationHeap
 	indexMap  map[string]*HeapItem
+
+	// watched keys and their versions, bumped on every change while someone WATCHes the key
+	watched    map[string]*watchedKey
+	versionSeq uint64
+
+	listFill       int // list-max-listpack-size of new lists
+	hashMaxEntries int // hash-max-listpack-entries
+	hashMaxValue   int // hash-max-listpack-value
+	setMaxIntset   int // set-max-intset-entries
+
+	done      chan struct{} // closed by Close to stop the background cleanup
+	closeOnce sync.Once
+}
+
+type watchedKey struct {
+	watchers int
+	version  uint64
 }
 
 func NewStore() *Store {
@@ -38,6 +92,13 @@ func NewStore() *Store {
 		data:      make(map[string]Value),
 		evictHeap: make(ExpirationHeap, 0),
 		indexMap:  make(map[string]*HeapItem),
+		watched:   make(map[string]*watchedKey),
+		listFill:  DefaultListMaxListpackSize,
+
+		hashMaxEntries: DefaultHashMaxListpackEntries,
+		hashMaxValue:   DefaultHashMaxListpackValue,
+		setMaxIntset:   DefaultSetMaxIntsetEntries,
+		done:           make(chan struct{}),
 	}
 	heap.Init(&s.evictHeap)
 
@@ -46,6 +107,185 @@ func NewStore() *Store {
 	return s
 }
 
+// Close stops the background expiration goroutine. The data stays readable.
+func (s *Store) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+/*
+Watch starts watching key for changes and returns its current version, to be compared with
+Version later. A key that has expired is deleted first, so expiring afterwards counts as a change.
+Every Watch must be paired with an Unwatch.
+*/
+func (s *Store) Watch(key string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireIfNeeded(key)
+	w, ok := s.watched[key]
+	if !ok {
+		w = &watchedKey{}
+		s.watched[key] = w
+	}
+	w.watchers++
+	return w.version
+}
+
+// Unwatch stops one Watch of key.
+func (s *Store) Unwatch(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.watched[key]
+	if !ok {
+		return
+	}
+	w.watchers--
+	if w.watchers == 0 {
+		delete(s.watched, key)
+	}
+}
+
+// Version returns the version of a watched key, it differs from the one Watch returned once the key has changed or expired.
+func (s *Store) Version(key string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireIfNeeded(key)
+	if w, ok := s.watched[key]; ok {
+		return w.version
+	}
+	return 0
+}
+
+// touch records a change to key for its watchers, it must be called with s.mu held on every mutation.
+func (s *Store) touch(key string) {
+	if w, ok := s.watched[key]; ok {
+		s.versionSeq++
+		w.version = s.versionSeq
+	}
+}
+
+// removeKey deletes key along with its expiration tracking, it must be called with s.mu held.
+func (s *Store) removeKey(key string) {
+	if val, ok := s.data[key]; ok && val.hash != nil {
+		s.dropFieldExpiries(val.hash)
+	}
+	delete(s.data, key)
+	if item, ok := s.indexMap[key]; ok {
+		heap.Remove(&s.evictHeap, item.index)
+		delete(s.indexMap, key)
+	}
+	s.touch(key)
+}
+
+// expireIfNeeded deletes key if it has expired, it must be called with s.mu held.
+func (s *Store) expireIfNeeded(key string) {
+	if val, ok := s.data[key]; ok && s.isExpired(val) {
+		s.removeKey(key)
+	}
+}
+
+/*
+lookup returns the live value at key, ok is false if there is none, and ErrWrongType if it
+holds something other than typ. Every operation on a typed value goes through it, so a new
+data type only needs an Encoding and its Type to get the checks. It must be called with
+s.mu held for writing, since it deletes the key if it has expired.
+*/
+func (s *Store) lookup(key string, typ Type) (val Value, ok bool, err error) {
+	s.expireIfNeeded(key)
+	return s.peek(key, typ)
+}
+
+// peek is lookup for operations that only read, it never deletes anything and an expired key is simply missing.
+func (s *Store) peek(key string, typ Type) (val Value, ok bool, err error) {
+	val, ok = s.data[key]
+	if !ok || s.isExpired(val) {
+		return Value{}, false, nil
+	}
+	if val.encoding.Type() != typ {
+		return Value{}, false, ErrWrongType
+	}
+	return val, true, nil
+}
+
+/*
+rlock locks s.mu for an operation that only reads keys and returns the function that
+unlocks it. Reads share the read lock, the write lock is only taken when one of keys has
+expired, or holds a hash with expired fields, to delete them before the operation runs.
+Under it values are looked up with peek.
+*/
+func (s *Store) rlock(keys ...string) (unlock func()) {
+	s.mu.RLock()
+	if !slices.ContainsFunc(keys, s.stale) {
+		return s.mu.RUnlock
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	for _, key := range keys {
+		s.expireIfNeeded(key)
+		if val, ok := s.data[key]; ok && val.hash != nil {
+			s.expireFields(key, val.hash)
+		}
+	}
+	return s.mu.Unlock
+}
+
+// stale reports whether key has expired, or holds a hash some fields of which have, and is waiting to be deleted.
+func (s *Store) stale(key string) bool {
+	val, ok := s.data[key]
+	if !ok {
+		return false
+	}
+	if s.isExpired(val) {
+		return true
+	}
+	h := val.hash
+	return h != nil && len(h.expires) > 0 && time.Now().UnixMilli() >= h.nextExpire
+}
+
+/*
+ObjectEncoding returns the name Redis gives to the encoding of the value at key, as reported
+by OBJECT ENCODING, and false if the key does not exist.
+*/
+func (s *Store) ObjectEncoding(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireIfNeeded(key)
+	val, ok := s.data[key]
+	if !ok {
+		return "", false
+	}
+	switch val.encoding {
+	case IntEncoding:
+		return "int", true
+	case ListEncoding:
+		// a list that fits in a single node is what Redis keeps as a plain listpack
+		if val.list.nodes <= 1 {
+			return "listpack", true
+		}
+		return "quicklist", true
+	case HashListpackEncoding:
+		if len(val.hash.expires) > 0 {
+			return "listpackex", true
+		}
+		return "listpack", true
+	case HashEncoding, SetEncoding:
+		return "hashtable", true
+	case SetIntsetEncoding:
+		return "intset", true
+	default:
+		if len(val.strVal) <= 44 {
+			return "embstr", true
+		}
+		return "raw", true
+	}
+}
+
 func (s *Store) Set(key string, value string, ttlSeconds int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -78,57 +318,47 @@ func (s *Store) Set(key string, value string, ttlSeconds int64) {
 		}
 	}
 
+	if old, ok := s.data[key]; ok && old.hash != nil {
+		s.dropFieldExpiries(old.hash)
+	}
 	s.data[key] = Value{
 		encoding:  StringEncoding,
 		strVal:    value,
 		expiresAt: expires,
 	}
+	s.touch(key)
 }
 
-func (s *Store) Get(key string) (string, bool) {
-	s.mu.RLock()
-	val, ok := s.data[key]
-	s.mu.RUnlock()
-
-	if !ok {
-		return "", false
-	}
+func (s *Store) Get(key string) (string, bool, error) {
+	unlock := s.rlock(key)
+	defer unlock()
 
-	if val.expiresAt > 0 && time.Now().UnixMilli() > val.expiresAt {
-		s.mu.Lock()
-		delete(s.data, key)
-		delete(s.indexMap, key)
-		s.mu.Unlock()
-		return "", false
+	val, ok, err := s.peek(key, TypeString)
+	if err != nil || !ok {
+		return "", false, err
 	}
 
 	if val.encoding == IntEncoding {
-		return strconv.FormatInt(val.intVal, 10), true
+		return strconv.FormatInt(val.intVal, 10), true, nil
 	}
 
-	return val.strVal, true
+	return val.strVal, true, nil
 }
 
 func (s *Store) Incr(key string) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	val, ok := s.data[key]
-	if !ok {
-		s.data[key] = Value{
-			encoding: IntEncoding,
-			intVal:   1,
-		}
-		return 1, nil
+	val, ok, err := s.lookup(key, TypeString)
+	if err != nil {
+		return 0, err
 	}
-
-	if val.expiresAt > 0 && time.Now().UnixMilli() > val.expiresAt {
-		delete(s.data, key)
-		delete(s.indexMap, key)
+	if !ok {
 		s.data[key] = Value{
 			encoding: IntEncoding,
 			intVal:   1,
 		}
+		s.touch(key)
 		return 1, nil
 	}
 
@@ -136,21 +366,22 @@ func (s *Store) Incr(key string) (int64, error) {
 	case IntEncoding:
 		val.intVal++
 		s.data[key] = val
+		s.touch(key)
 		return val.intVal, nil
 
-	case StringEncoding:
+	default:
 		parsed, err := strconv.ParseInt(val.strVal, 10, 64)
 		if err != nil {
-			return 0, fmt.Errorf("ERR value is not an integer or out of range")
+			return 0, ErrNotInteger
 		}
 		parsed++
 		val.encoding = IntEncoding
 		val.intVal = parsed
 		val.strVal = ""
 		s.data[key] = val
+		s.touch(key)
 		return parsed, nil
 	}
-	return 0, nil
 }
 
 func (s *Store) Del(keys []string) int {
@@ -166,17 +397,10 @@ func (s *Store) Del(keys []string) int {
 			continue
 		}
 
-		if item, ok := s.indexMap[key]; ok {
-			heap.Remove(&s.evictHeap, item.index)
-			delete(s.indexMap, key)
-		}
-
+		s.removeKey(key)
 		if val.expiresAt > 0 && now > val.expiresAt {
-			delete(s.data, key)
 			continue
 		}
-
-		delete(s.data, key)
 		count++
 	}
 
@@ -198,8 +422,7 @@ func (s *Store) TTL(key string) int64 {
 
 	if s.isExpired(val) {
 		s.mu.Lock()
-		delete(s.data, key)
-		delete(s.indexMap, key)
+		s.expireIfNeeded(key)
 		s.mu.Unlock()
 		return -2
 	}
@@ -215,149 +438,12 @@ func (s *Store) TTL(key string) int64 {
 	return ttl
 }
 
-func (s *Store) LPush(key string, values ...string) int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	val, ok := s.data[key]
-	if !ok {
-		s.data[key] = Value{
-			encoding: ListEncoding,
-			listVal:  make([]string, 0),
-		}
-		val = s.data[key]
-	}
-
-	if val.encoding != ListEncoding {
-		log.Printf("ERR WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-	// prepend values
-	val.listVal = append(values, val.listVal...)
-	s.data[key] = val
-	return len(val.listVal)
-}
-
-func (s *Store) RPush(key string, values ...string) int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	val, ok := s.data[key]
-	if !ok {
-		s.data[key] = Value{
-			encoding: ListEncoding,
-			listVal:  make([]string, 0),
-		}
-		val = s.data[key]
-	}
-
-	if val.encoding != ListEncoding {
-		log.Printf("ERR WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-	// append values
-	val.listVal = append(val.listVal, values...)
-	s.data[key] = val
-	return len(val.listVal)
-}
-
-func (s *Store) LPop(key string) (string, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	val, ok := s.data[key]
-
-	if !ok || val.encoding != ListEncoding || len(val.listVal) == 0 {
-		return "", false
-	}
-
-	item := val.listVal[0]
-
-	val.listVal = val.listVal[1:]
-
-	if len(val.listVal) == 0 {
-		delete(s.data, key)
-	} else {
-		s.data[key] = val
-	}
-
-	return item, true
-}
-
-func (s *Store) RPop(key string) (string, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	val, ok := s.data[key]
-
-	if !ok || val.encoding != ListEncoding || len(val.listVal) == 0 {
-		return "", false
-	}
-
-	idx := len(val.listVal) - 1
-	item := val.listVal[idx]
-
-	val.listVal = val.listVal[:idx]
-
-	if len(val.listVal) == 0 {
-		delete(s.data, key)
-	} else {
-		s.data[key] = val
-	}
-
-	return item, true
-}
-
-func (s *Store) LRange(key string, start, stop int) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	val, ok := s.data[key]
-	if !ok {
-		return []string{}
-	}
-
-	if val.encoding != ListEncoding {
-		log.Printf("ERR WRONGTYPE Operation against a key holding the wrong kind of value")
-		return []string{}
-	}
-
-	list := val.listVal
-	length := len(list)
-
-	if length == 0 {
-		return []string{}
-	}
-
-	// handle negative indexes
-	if start < 0 {
-		start = length + start
-	}
-	if stop < 0 {
-		stop = length + stop
-	}
-
-	// clamp start
-	if start < 0 {
-		start = 0
-	}
-
-	// clamp stop
-	if stop >= length {
-		stop = length - 1
-	}
-
-	// if start is beyond list or start > stop → empty result
-	if start >= length || start > stop {
-		return []string{}
-	}
-
-	// Redis stop is inclusive, Go slices are exclusive
-	return list[start : stop+1]
-}
-
 // --- Heap ---
 
 type HeapItem struct {
 	key       string
+	field     string // the hash field that expires, when isField is set
+	isField   bool
 	expiresAt int64
 	index     int
 }
@@ -401,7 +487,13 @@ func (s *Store) cleanupExpiredKeys() {
 		sampleSize   = 20                    // keys to sample per loop
 	)
 
-	for range ticker.C {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
@@ -421,10 +513,24 @@ func (s *Store) cleanupExpiredKeys() {
 				// these are keys we already flagged as expiring soon
 				for s.evictHeap.Len() > 0 {
 					item := s.evictHeap[0]
-					if item.expiresAt <= now {
+					if item.isField {
+						if !s.fieldTracked(item) {
+							heap.Pop(&s.evictHeap)
+							continue
+						}
+						if item.expiresAt > now {
+							break
+						}
+						s.expireField(item)
+						continue
+					}
+					if s.indexMap[item.key] != item {
+						// stale entry for a key no longer tracked, never delete through it
 						heap.Pop(&s.evictHeap)
-						delete(s.data, item.key)
-						delete(s.indexMap, item.key)
+						continue
+					}
+					if item.expiresAt <= now {
+						s.removeKey(item.key)
 					} else {
 						break
 					}
@@ -462,11 +568,7 @@ func (s *Store) cleanupExpiredKeys() {
 
 					if val.expiresAt <= now {
 						// expired — delete immediately
-						delete(s.data, key)
-						if item, ok := s.indexMap[key]; ok {
-							heap.Remove(&s.evictHeap, item.index)
-							delete(s.indexMap, key)
-						}
+						s.removeKey(key)
 						expiredCount++
 					} else if val.expiresAt <= soonThreshold {
 						// expiring soon — track in heap for next cycle
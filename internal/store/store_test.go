@@ -0,0 +1,186 @@
+package store
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCloseKeepsDataReadable(t *testing.T) {
+	s := NewStore()
+	s.Set("k", "v", 0)
+	s.Close()
+	s.Close() // closing twice is fine
+
+	if v, ok, err := s.Get("k"); err != nil || !ok || v != "v" {
+		t.Fatalf("Get after Close = %q, %v, %v", v, ok, err)
+	}
+}
+
+func TestCloseStopsActiveExpiry(t *testing.T) {
+	s := NewStore()
+	s.Close()
+	// give the cleanup goroutine time to notice
+	time.Sleep(150 * time.Millisecond)
+
+	s.Set("k", "v", 1)
+	time.Sleep(1300 * time.Millisecond)
+	s.mu.RLock()
+	_, stored := s.data["k"]
+	s.mu.RUnlock()
+	if !stored {
+		t.Fatal("an expired key was reclaimed in the background after Close")
+	}
+	// lazy expiry still works
+	if _, ok, _ := s.Get("k"); ok {
+		t.Fatal("Get returned an expired key")
+	}
+}
+
+func TestWatchVersion(t *testing.T) {
+	s := NewStore()
+	defer s.Close()
+
+	v := s.Watch("k")
+	s.Set("other", "x", 0)
+	if s.Version("k") != v {
+		t.Fatal("writing another key changed the version")
+	}
+	s.Set("k", "x", 0)
+	if s.Version("k") == v {
+		t.Fatal("writing the key did not change the version")
+	}
+	s.Unwatch("k")
+}
+
+func TestPopNothingKeepsVersion(t *testing.T) {
+	s := NewStore()
+	defer s.Close()
+
+	s.RPush("l", "a")
+	v := s.Watch("l")
+	if items, err := s.PopN("l", 0, true); err != nil || len(items) != 0 {
+		t.Fatalf("PopN with count 0 = %q, %v", items, err)
+	}
+	if s.Version("l") != v {
+		t.Fatal("popping nothing changed the version")
+	}
+	s.PopN("l", 1, true)
+	if s.Version("l") == v {
+		t.Fatal("popping an element did not change the version")
+	}
+	s.Unwatch("l")
+}
+
+func TestReadsShareTheLock(t *testing.T) {
+	s := NewStore()
+	s.Close()
+	time.Sleep(150 * time.Millisecond)
+
+	s.Set("k", "v", 0)
+	s.RPush("l", "a")
+	s.SAdd("s", "a")
+	s.HSet("h", "f", "v")
+
+	// with another reader holding the lock, reads still go through
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Get("k")
+		s.LRange("l", 0, -1)
+		s.SMembers("s")
+		s.SInter("s", "t")
+		s.HGetAll("h")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reads waited for the write lock")
+	}
+}
+
+func TestReadsDeleteExpiredKeys(t *testing.T) {
+	s := NewStore()
+	s.Close()
+	time.Sleep(150 * time.Millisecond)
+
+	s.Set("k", "v", 100)
+	s.RPush("l", "a")
+	s.HSet("h", "f", "v", "g", "w")
+	past := time.Now().UnixMilli() - 1
+	s.mu.Lock()
+	for _, key := range []string{"k", "l"} {
+		val := s.data[key]
+		val.expiresAt = past
+		s.data[key] = val
+	}
+	s.mu.Unlock()
+	if _, err := s.HExpire("h", time.Now().UnixMilli()+20, "", []string{"f"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if _, ok, _ := s.Get("k"); ok {
+		t.Fatal("Get returned an expired key")
+	}
+	if n, _ := s.LLen("l"); n != 0 {
+		t.Fatalf("LLen of an expired list = %d", n)
+	}
+	if pairs, _ := s.HGetAll("h"); len(pairs) != 2 || pairs[0] != "g" {
+		t.Fatalf("HGetAll = %q, want the field without a TTL", pairs)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.data["k"]; ok {
+		t.Fatal("the expired string was not deleted")
+	}
+	if _, ok := s.data["l"]; ok {
+		t.Fatal("the expired list was not deleted")
+	}
+	if _, ok := s.data["h"].hash.get("f"); ok {
+		t.Fatal("the expired field was not deleted")
+	}
+}
+
+func TestSetPopAndSample(t *testing.T) {
+	s := NewStore()
+	defer s.Close()
+	// an intset and a hashtable
+	for _, key := range []string{"ints", "strs"} {
+		for i := 0; i < 100; i++ {
+			m := strconv.Itoa(i)
+			if key == "strs" {
+				m = "m" + m
+			}
+			s.SAdd(key, m)
+		}
+
+		members, _ := s.SRandMember(key, 10)
+		if len(members) != 10 || len(slices.Compact(slices.Sorted(slices.Values(members)))) != 10 {
+			t.Fatalf("%s: SRandMember 10 = %q, want 10 distinct members", key, members)
+		}
+		if members, _ := s.SRandMember(key, -500); len(members) != 500 {
+			t.Fatalf("%s: SRandMember -500 returned %d members", key, len(members))
+		}
+
+		left := 100
+		for _, count := range []int{1, 10, 50, 100} {
+			popped, err := s.SPop(key, count)
+			if err != nil || len(popped) != min(count, left) {
+				t.Fatalf("%s: SPop %d = %q, %v with %d members left", key, count, popped, err, left)
+			}
+			left -= len(popped)
+			for _, m := range popped {
+				if ok, _ := s.SIsMember(key, m); ok {
+					t.Fatalf("%s: %s is still in the set after SPop", key, m)
+				}
+			}
+			if n, _ := s.SCard(key); n != left {
+				t.Fatalf("%s: SCard %d after popping, want %d", key, n, left)
+			}
+		}
+	}
+}